@@ -3,78 +3,213 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 
-	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
 )
 
 var (
 	ErrNotFound             = errors.New("auth: resource not found")
 	ErrAuthenticationFailed = errors.New("auth: authentication failed")
+	ErrUnsupportedProvider  = errors.New("auth: unsupported provider")
 )
 
+type Provider interface {
+	AuthCodeURL(authentication Authentication) string
+	Identify(ctx context.Context, authentication Authentication, code string) (*Identity, error)
+	// EndSessionURL returns where to send the user to end the provider
+	// session, or an empty string when the provider does not support it.
+	EndSessionURL(idTokenHint, postLogoutRedirectURI string) string
+}
+
+// Authentication holds the values generated for a single login, which must be
+// kept by the caller until the provider redirects back.
+type Authentication struct {
+	URL          string
+	Provider     string
+	State        string
+	CodeVerifier string
+	Nonce        string
+}
+
+// authCodeOptions returns the PKCE challenge derived with S256 from the code
+// verifier.
+func (a Authentication) authCodeOptions() []oauth2.AuthCodeOption {
+	challenge := sha256.Sum256([]byte(a.CodeVerifier))
+
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+func (a Authentication) exchangeOptions() []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_verifier", a.CodeVerifier),
+	}
+}
+
+// Identity is the user authenticated by a provider. Its claims follow the
+// OIDC standard claim names regardless of the provider. RawIDToken is empty
+// for providers that do not issue ID tokens.
+type Identity struct {
+	Provider   string
+	Subject    string
+	RawIDToken string
+	claims     json.RawMessage
+}
+
+func NewIdentity(provider string, claims []byte) (*Identity, error) {
+	var c struct {
+		Sub string `json:"sub"`
+	}
+
+	if err := json.Unmarshal(claims, &c); err != nil {
+		return nil, fmt.Errorf("could not unmarshal claims: %v", err)
+	}
+
+	return &Identity{
+		Provider: provider,
+		Subject:  c.Sub,
+		claims:   claims,
+	}, nil
+}
+
+func (i *Identity) Claims(v interface{}) error {
+	return json.Unmarshal(i.claims, v)
+}
+
 type Authenticator struct {
-	provider     *oidc.Provider
-	config       oauth2.Config
-	clientID     string
-	clientSecret string
+	providers       map[string]Provider
+	defaultProvider string
+}
+
+// NewAuthenticator registers a provider for every config. The first one is
+// used when no provider is requested.
+func NewAuthenticator(configs ...Config) (*Authenticator, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%w: at least one provider is required", ErrInvalidConfig)
+	}
+
+	a := &Authenticator{
+		providers:       make(map[string]Provider, len(configs)),
+		defaultProvider: configs[0].Name,
+	}
+
+	for _, config := range configs {
+		if _, exist := a.providers[config.Name]; exist {
+			return nil, fmt.Errorf("%w: duplicated provider: %s", ErrInvalidConfig, config.Name)
+		}
+
+		provider, err := newProvider(config)
+		if err != nil {
+			return nil, err
+		}
+
+		a.providers[config.Name] = provider
+	}
+
+	return a, nil
 }
 
-func NewAuthenticator(baseURL string, clientID string, clientSecret string) (*Authenticator, error) {
-	ctx := context.Background()
+func newProvider(config Config) (Provider, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case TypeGitHub:
+		return newGitHubProvider(config), nil
+	default:
+		return newOIDCProvider(config)
+	}
+}
 
-	provider, err := oidc.NewProvider(ctx, "https://food4everyone.us.auth0.com/")
+func (a *Authenticator) CreateAuthentication(provider string) (Authentication, error) {
+	p, err := a.provider(provider)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get provider: %v", err)
+		return Authentication{}, err
 	}
 
-	conf := oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  fmt.Sprintf("%s/login/callback", baseURL),
-		Endpoint:     provider.Endpoint(),
-		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	CSRFState, err := randomString()
+	if err != nil {
+		return Authentication{}, err
 	}
 
-	return &Authenticator{
-		provider:     provider,
-		config:       conf,
-		clientID:     clientID,
-		clientSecret: clientSecret,
-	}, nil
+	codeVerifier, err := randomString()
+	if err != nil {
+		return Authentication{}, err
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return Authentication{}, err
+	}
+
+	authentication := Authentication{
+		Provider:     provider,
+		State:        CSRFState,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+	}
+
+	authentication.URL = p.AuthCodeURL(authentication)
+	return authentication, nil
 }
 
-func (a *Authenticator) CreateAuthentication() (url, state string, err error) {
-	b := make([]byte, 32)
-	_, err = rand.Read(b)
+func (a *Authenticator) VerifyAuthentication(ctx context.Context, authentication Authentication, code string) (*Identity, error) {
+	p, err := a.provider(authentication.Provider)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	CSRFState := base64.StdEncoding.EncodeToString(b)
-	return a.config.AuthCodeURL(CSRFState), CSRFState, nil
+	return p.Identify(ctx, authentication, code)
 }
 
-func (a *Authenticator) VerifyAuthentication(ctx context.Context, code string) (*oidc.IDToken, error) {
-	token, err := a.config.Exchange(ctx, code)
+func (a *Authenticator) EndSessionURL(provider, idTokenHint, postLogoutRedirectURI string) (string, error) {
+	p, err := a.provider(provider)
 	if err != nil {
-		return nil, fmt.Errorf("could not exchange code %w: %v", ErrNotFound, err)
+		return "", err
+	}
+
+	return p.EndSessionURL(idTokenHint, postLogoutRedirectURI), nil
+}
+
+func (a *Authenticator) provider(name string) (Provider, error) {
+	if name == "" {
+		name = a.defaultProvider
 	}
 
-	rawIDToken, exist := token.Extra("id_token").(string)
+	p, exist := a.providers[name]
 	if !exist {
-		return nil, fmt.Errorf("could not find id_token %w: %v", ErrNotFound, err)
+		return nil, fmt.Errorf("%w: got: (%s)", ErrUnsupportedProvider, name)
 	}
 
-	cfg := &oidc.Config{ClientID: a.clientID}
+	return p, nil
+}
 
-	idToken, err := a.provider.Verifier(cfg).Verify(ctx, rawIDToken)
-	if err != nil {
-		return nil, fmt.Errorf("could not verify token %w: %v", ErrAuthenticationFailed, err)
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func authCodeOptions(config Config) []oauth2.AuthCodeOption {
+	var opts []oauth2.AuthCodeOption
+	if config.Audience != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("audience", config.Audience))
+	}
+
+	for key, value := range config.AuthParams {
+		opts = append(opts, oauth2.SetAuthURLParam(key, value))
 	}
 
-	return idToken, nil
+	return opts
 }
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newGitHubServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "_verifier_", r.FormValue("code_verifier"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"_access_token_","token_type":"bearer"}`))
+	})
+
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer _access_token_", r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"id":1234,"login":"octocat","name":"","avatar_url":"_avatar_"}`))
+	})
+
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"email":"other@example.com","primary":false,"verified":true},{"email":"octocat@example.com","primary":true,"verified":true}]`))
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func newGitHubConfig(baseURL string) Config {
+	return Config{
+		Name:         "github",
+		Type:         TypeGitHub,
+		AuthURL:      baseURL + "/login/oauth/authorize",
+		TokenURL:     baseURL + "/login/oauth/access_token",
+		UserInfoURL:  baseURL + "/user",
+		ClientID:     "_client_id_",
+		ClientSecret: "_client_secret_",
+		BaseURL:      "http://localhost:8080",
+		RedirectPath: "/login/callback",
+		Scopes:       []string{"read:user", "user:email"},
+		AuthParams:   map[string]string{"allow_signup": "false"},
+	}
+}
+
+func TestAuthenticator_CreateAuthentication(t *testing.T) {
+	// Given
+	a, err := NewAuthenticator(newGitHubConfig("https://github.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	authentication, err := a.CreateAuthentication("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	challenge := sha256.Sum256([]byte(authentication.CodeVerifier))
+
+	u, err := url.Parse(authentication.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	require.Equal(t, "github.example.com", u.Host)
+	require.Equal(t, authentication.State, u.Query().Get("state"))
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(challenge[:]), u.Query().Get("code_challenge"))
+	require.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	require.NotEqual(t, authentication.State, authentication.CodeVerifier)
+	require.Equal(t, "false", u.Query().Get("allow_signup"))
+	require.Equal(t, "http://localhost:8080/login/callback", u.Query().Get("redirect_uri"))
+}
+
+func TestAuthenticator_UnsupportedProviderError(t *testing.T) {
+	// Given
+	a, err := NewAuthenticator(newGitHubConfig("https://github.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	_, createErr := a.CreateAuthentication("unknown")
+	_, verifyErr := a.VerifyAuthentication(context.Background(), Authentication{Provider: "unknown"}, "_code_")
+
+	// Then
+	require.True(t, errors.Is(createErr, ErrUnsupportedProvider))
+	require.True(t, errors.Is(verifyErr, ErrUnsupportedProvider))
+}
+
+func TestNewAuthenticator_InvalidConfigError(t *testing.T) {
+	tt := []struct {
+		name    string
+		configs []Config
+	}{
+		{
+			name: "no providers",
+		},
+		{
+			name:    "duplicated provider",
+			configs: []Config{newGitHubConfig("https://github.example.com"), newGitHubConfig("https://github.example.com")},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// When
+			_, err := NewAuthenticator(tc.configs...)
+
+			// Then
+			require.True(t, errors.Is(err, ErrInvalidConfig))
+		})
+	}
+}
+
+func TestAuthenticator_VerifyAuthentication_GitHub(t *testing.T) {
+	// Given
+	ts := newGitHubServer(t)
+
+	a, err := NewAuthenticator(newGitHubConfig(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	identity, err := a.VerifyAuthentication(context.Background(), Authentication{Provider: "github", CodeVerifier: "_verifier_"}, "_code_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	var claims struct {
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Picture       string `json:"picture"`
+	}
+
+	if err := identity.Claims(&claims); err != nil {
+		t.Fatal(err)
+	}
+
+	require.Equal(t, "github", identity.Provider)
+	require.Equal(t, "github|1234", identity.Subject)
+	require.Equal(t, "octocat", claims.Name)
+	require.Equal(t, "octocat@example.com", claims.Email)
+	require.True(t, claims.EmailVerified)
+	require.Equal(t, "_avatar_", claims.Picture)
+}
+
+func TestAuthenticator_VerifyAuthentication_GitHubUserError(t *testing.T) {
+	// Given
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login/oauth/access_token" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"_access_token_","token_type":"bearer"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	a, err := NewAuthenticator(newGitHubConfig(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	_, err = a.VerifyAuthentication(context.Background(), Authentication{Provider: "github", CodeVerifier: "_verifier_"}, "_code_")
+
+	// Then
+	require.True(t, errors.Is(err, ErrAuthenticationFailed))
+}
+
+func TestAuthenticator_EndSessionURL_GitHub(t *testing.T) {
+	// Given
+	a, err := NewAuthenticator(newGitHubConfig("https://github.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	endSessionURL, err := a.EndSessionURL("github", "", "https://app.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Empty(t, endSessionURL)
+}
+
+func TestNewIdentity(t *testing.T) {
+	// Given
+	b, _ := json.Marshal(map[string]string{"sub": "_sub_", "name": "_name_"})
+
+	// When
+	identity, err := NewIdentity("google", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	var claims struct {
+		Name string `json:"name"`
+	}
+
+	require.NoError(t, identity.Claims(&claims))
+	require.Equal(t, "_sub_", identity.Subject)
+	require.Equal(t, "_name_", claims.Name)
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+var ErrInvalidConfig = errors.New("auth: invalid config")
+
+const (
+	TypeOIDC   = "oidc"
+	TypeGitHub = "github"
+)
+
+type Config struct {
+	Name         string
+	Type         string
+	IssuerURL    string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	ClientID     string
+	ClientSecret string
+	BaseURL      string
+	RedirectPath string
+	Scopes       []string
+	Audience     string
+	AuthParams   map[string]string
+	// AllowedSubjectPrefixes restricts an OIDC provider to the users whose
+	// subject starts with one of them, e.g. "google-oauth2|" to only accept
+	// the google connection of an Auth0 tenant. Any subject is accepted when
+	// empty.
+	AllowedSubjectPrefixes []string
+	// Auth0 marks an OIDC provider as an Auth0 tenant on a custom domain, so
+	// its /v2/logout endpoint ends the session when it advertises no
+	// end_session_endpoint. Issuers on auth0.com are always treated as such.
+	Auth0 bool
+}
+
+func (c Config) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidConfig)
+	}
+
+	switch c.Type {
+	case TypeOIDC:
+		if err := validateURL(c.IssuerURL); err != nil {
+			return fmt.Errorf("%w: %s: issuer url: %v", ErrInvalidConfig, c.Name, err)
+		}
+	case TypeGitHub:
+		for _, u := range []string{c.AuthURL, c.TokenURL, c.UserInfoURL} {
+			if u == "" {
+				continue
+			}
+
+			if err := validateURL(u); err != nil {
+				return fmt.Errorf("%w: %s: endpoint url: %v", ErrInvalidConfig, c.Name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: %s: unsupported type: got: (%s), want: (%s or %s)", ErrInvalidConfig, c.Name, c.Type, TypeOIDC, TypeGitHub)
+	}
+
+	if err := validateURL(c.BaseURL); err != nil {
+		return fmt.Errorf("%w: %s: base url: %v", ErrInvalidConfig, c.Name, err)
+	}
+
+	if c.ClientID == "" {
+		return fmt.Errorf("%w: %s: client id is required", ErrInvalidConfig, c.Name)
+	}
+
+	if c.ClientSecret == "" {
+		return fmt.Errorf("%w: %s: client secret is required", ErrInvalidConfig, c.Name)
+	}
+
+	if !strings.HasPrefix(c.RedirectPath, "/") {
+		return fmt.Errorf("%w: %s: redirect path must start with /: got: (%s)", ErrInvalidConfig, c.Name, c.RedirectPath)
+	}
+
+	if c.Type != TypeOIDC {
+		return nil
+	}
+
+	for _, scope := range c.Scopes {
+		if scope == oidc.ScopeOpenID {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s: scopes must include %s", ErrInvalidConfig, c.Name, oidc.ScopeOpenID)
+}
+
+// IsAuth0 reports whether the issuer is an Auth0 tenant.
+func (c Config) IsAuth0() bool {
+	if c.Auth0 {
+		return true
+	}
+
+	u, err := url.Parse(c.IssuerURL)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasSuffix(u.Hostname(), ".auth0.com")
+}
+
+func (c Config) RedirectURL() string {
+	return strings.TrimSuffix(c.BaseURL, "/") + c.RedirectPath
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("must be an absolute http(s) url: got: (%s)", rawURL)
+	}
+
+	return nil
+}
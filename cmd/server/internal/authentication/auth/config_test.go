@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newConfig() Config {
+	return Config{
+		Name:         "google",
+		Type:         TypeOIDC,
+		IssuerURL:    "https://issuer.example.com/",
+		ClientID:     "_client_id_",
+		ClientSecret: "_client_secret_",
+		BaseURL:      "http://localhost:8080",
+		RedirectPath: "/login/callback",
+		Scopes:       []string{"openid", "profile", "email"},
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	// Given
+	config := newConfig()
+
+	// When
+	err := config.Validate()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:8080/login/callback", config.RedirectURL())
+}
+
+func TestConfig_Validate_InvalidConfigError(t *testing.T) {
+	tt := []struct {
+		name          string
+		modify        func(c *Config)
+		expectedError string
+	}{
+		{
+			name:          "relative issuer url",
+			modify:        func(c *Config) { c.IssuerURL = "issuer.example.com" },
+			expectedError: "auth: invalid config: google: issuer url: must be an absolute http(s) url: got: (issuer.example.com)",
+		},
+		{
+			name:          "missing base url",
+			modify:        func(c *Config) { c.BaseURL = "" },
+			expectedError: "auth: invalid config: google: base url: must be an absolute http(s) url: got: ()",
+		},
+		{
+			name:          "missing client id",
+			modify:        func(c *Config) { c.ClientID = "" },
+			expectedError: "auth: invalid config: google: client id is required",
+		},
+		{
+			name:          "missing client secret",
+			modify:        func(c *Config) { c.ClientSecret = "" },
+			expectedError: "auth: invalid config: google: client secret is required",
+		},
+		{
+			name:          "relative redirect path",
+			modify:        func(c *Config) { c.RedirectPath = "login/callback" },
+			expectedError: "auth: invalid config: google: redirect path must start with /: got: (login/callback)",
+		},
+		{
+			name:          "missing name",
+			modify:        func(c *Config) { c.Name = "" },
+			expectedError: "auth: invalid config: name is required",
+		},
+		{
+			name:          "unsupported type",
+			modify:        func(c *Config) { c.Type = "saml" },
+			expectedError: "auth: invalid config: google: unsupported type: got: (saml), want: (oidc or github)",
+		},
+		{
+			name:          "relative github endpoint",
+			modify:        func(c *Config) { c.Type = TypeGitHub; c.UserInfoURL = "api.github.com/user" },
+			expectedError: "auth: invalid config: google: endpoint url: must be an absolute http(s) url: got: (api.github.com/user)",
+		},
+		{
+			name:          "missing openid scope",
+			modify:        func(c *Config) { c.Scopes = []string{"profile"} },
+			expectedError: "auth: invalid config: google: scopes must include openid",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			config := newConfig()
+			tc.modify(&config)
+
+			// When
+			err := config.Validate()
+
+			// Then
+			require.True(t, errors.Is(err, ErrInvalidConfig))
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestConfig_Validate_GitHubDoesNotRequireOpenIDScope(t *testing.T) {
+	// Given
+	config := newConfig()
+	config.Type = TypeGitHub
+	config.IssuerURL = ""
+	config.Scopes = []string{"read:user", "user:email"}
+
+	// When
+	err := config.Validate()
+
+	// Then
+	require.NoError(t, err)
+}
+
+func TestConfig_IsAuth0(t *testing.T) {
+	tt := []struct {
+		name     string
+		modify   func(c *Config)
+		expected bool
+	}{
+		{
+			name:     "auth0 tenant",
+			modify:   func(c *Config) { c.IssuerURL = "https://tenant.us.auth0.com/" },
+			expected: true,
+		},
+		{
+			name:     "auth0 custom domain",
+			modify:   func(c *Config) { c.IssuerURL = "https://login.example.com/"; c.Auth0 = true },
+			expected: true,
+		},
+		{
+			name:   "other provider",
+			modify: func(c *Config) { c.IssuerURL = "https://accounts.google.com" },
+		},
+		{
+			name:   "auth0 lookalike",
+			modify: func(c *Config) { c.IssuerURL = "https://auth0.com.example.com/" },
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			config := newConfig()
+			tc.modify(&config)
+
+			// When
+			isAuth0 := config.IsAuth0()
+
+			// Then
+			require.Equal(t, tc.expected, isAuth0)
+		})
+	}
+}
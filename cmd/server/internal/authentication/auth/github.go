@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const defaultGitHubUserInfoURL = "https://api.github.com/user"
+
+// gitHubProvider authenticates users with plain OAuth2 and builds their
+// identity from the GitHub user API, since GitHub does not issue ID tokens.
+type gitHubProvider struct {
+	name        string
+	config      oauth2.Config
+	userInfoURL string
+	opts        []oauth2.AuthCodeOption
+}
+
+func newGitHubProvider(config Config) *gitHubProvider {
+	endpoint := github.Endpoint
+	if config.AuthURL != "" {
+		endpoint.AuthURL = config.AuthURL
+	}
+
+	if config.TokenURL != "" {
+		endpoint.TokenURL = config.TokenURL
+	}
+
+	userInfoURL := config.UserInfoURL
+	if userInfoURL == "" {
+		userInfoURL = defaultGitHubUserInfoURL
+	}
+
+	conf := oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  config.RedirectURL(),
+		Endpoint:     endpoint,
+		Scopes:       config.Scopes,
+	}
+
+	return &gitHubProvider{
+		name:        config.Name,
+		config:      conf,
+		userInfoURL: userInfoURL,
+		opts:        authCodeOptions(config),
+	}
+}
+
+func (p *gitHubProvider) AuthCodeURL(authentication Authentication) string {
+	return p.config.AuthCodeURL(authentication.State, append(authentication.authCodeOptions(), p.opts...)...)
+}
+
+func (p *gitHubProvider) Identify(ctx context.Context, authentication Authentication, code string) (*Identity, error) {
+	token, err := p.config.Exchange(ctx, code, authentication.exchangeOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange code %w: %v", ErrNotFound, err)
+	}
+
+	client := p.config.Client(ctx, token)
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+	}
+
+	if err := getJSON(client, p.userInfoURL, &user); err != nil {
+		return nil, fmt.Errorf("could not fetch user %w: %v", ErrAuthenticationFailed, err)
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	if err := getJSON(client, p.userInfoURL+"/emails", &emails); err != nil {
+		return nil, fmt.Errorf("could not fetch user emails %w: %v", ErrAuthenticationFailed, err)
+	}
+
+	var emailVerified bool
+	for _, email := range emails {
+		if email.Primary {
+			user.Email = email.Email
+			emailVerified = email.Verified
+		}
+	}
+
+	name := user.Name
+	if name == "" {
+		name = user.Login
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"sub":                p.name + "|" + strconv.FormatInt(user.ID, 10),
+		"name":               name,
+		"nickname":           user.Login,
+		"email":              user.Email,
+		"email_verified":     emailVerified,
+		"picture":            user.AvatarURL,
+		"preferred_username": user.Login,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal claims: %v", err)
+	}
+
+	return NewIdentity(p.name, claims)
+}
+
+// EndSessionURL returns an empty URL because GitHub has no logout endpoint for
+// OAuth apps.
+func (p *gitHubProvider) EndSessionURL(idTokenHint, postLogoutRedirectURI string) string {
+	return ""
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
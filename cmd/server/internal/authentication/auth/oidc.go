@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+type oidcProvider struct {
+	name       string
+	provider   *oidc.Provider
+	config     oauth2.Config
+	clientID   string
+	opts       []oauth2.AuthCodeOption
+	endSession string
+	auth0      bool
+	subjects   []string
+}
+
+func newOIDCProvider(config Config) (*oidcProvider, error) {
+	ctx := context.Background()
+
+	provider, err := oidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider: %v", err)
+	}
+
+	conf := oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  config.RedirectURL(),
+		Endpoint:     provider.Endpoint(),
+		Scopes:       config.Scopes,
+	}
+
+	var metadata struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+
+	if err := provider.Claims(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to get provider metadata: %v", err)
+	}
+
+	// Auth0 does not advertise an end_session_endpoint unless RP-initiated
+	// logout is enabled for the tenant, but /v2/logout is always available.
+	// Other providers without one have no session to end.
+	endSession, auth0 := metadata.EndSessionEndpoint, false
+	if endSession == "" && config.IsAuth0() {
+		endSession, auth0 = strings.TrimSuffix(config.IssuerURL, "/")+"/v2/logout", true
+	}
+
+	return &oidcProvider{
+		name:       config.Name,
+		provider:   provider,
+		config:     conf,
+		clientID:   config.ClientID,
+		opts:       authCodeOptions(config),
+		endSession: endSession,
+		auth0:      auth0,
+		subjects:   config.AllowedSubjectPrefixes,
+	}, nil
+}
+
+func (p *oidcProvider) AuthCodeURL(authentication Authentication) string {
+	opts := append(authentication.authCodeOptions(), p.opts...)
+	opts = append(opts, oidc.Nonce(authentication.Nonce))
+
+	return p.config.AuthCodeURL(authentication.State, opts...)
+}
+
+func (p *oidcProvider) Identify(ctx context.Context, authentication Authentication, code string) (*Identity, error) {
+	token, err := p.config.Exchange(ctx, code, authentication.exchangeOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange code %w: %v", ErrNotFound, err)
+	}
+
+	rawIDToken, exist := token.Extra("id_token").(string)
+	if !exist {
+		return nil, fmt.Errorf("could not find id_token %w: %v", ErrNotFound, err)
+	}
+
+	cfg := &oidc.Config{ClientID: p.clientID}
+
+	idToken, err := p.provider.Verifier(cfg).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify token %w: %v", ErrAuthenticationFailed, err)
+	}
+
+	if authentication.Nonce == "" || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(authentication.Nonce)) != 1 {
+		return nil, fmt.Errorf("could not verify nonce: %w", ErrAuthenticationFailed)
+	}
+
+	var claims json.RawMessage
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("could not fetch claims %w: %v", ErrAuthenticationFailed, err)
+	}
+
+	identity, err := NewIdentity(p.name, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	// The connection asked for in the authorization URL is only a hint the
+	// user can remove, so the subject is what tells which one was used.
+	if !p.isAllowedSubject(identity.Subject) {
+		return nil, fmt.Errorf("%w: subject of an unexpected connection: got: (%s), want: (%s)", ErrAuthenticationFailed, identity.Subject, strings.Join(p.subjects, " or "))
+	}
+
+	identity.RawIDToken = rawIDToken
+	return identity, nil
+}
+
+func (p *oidcProvider) isAllowedSubject(subject string) bool {
+	if len(p.subjects) == 0 {
+		return true
+	}
+
+	for _, prefix := range p.subjects {
+		if strings.HasPrefix(subject, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *oidcProvider) EndSessionURL(idTokenHint, postLogoutRedirectURI string) string {
+	if p.endSession == "" {
+		return ""
+	}
+
+	params := url.Values{"client_id": {p.clientID}}
+	if p.auth0 {
+		if postLogoutRedirectURI != "" {
+			params.Set("returnTo", postLogoutRedirectURI)
+		}
+
+		return p.endSession + "?" + params.Encode()
+	}
+
+	if idTokenHint != "" {
+		params.Set("id_token_hint", idTokenHint)
+	}
+
+	if postLogoutRedirectURI != "" {
+		params.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	}
+
+	separator := "?"
+	if strings.Contains(p.endSession, "?") {
+		separator = "&"
+	}
+
+	return p.endSession + separator + params.Encode()
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+type issuer struct {
+	*httptest.Server
+	nonce      string
+	subject    string
+	endSession bool
+}
+
+// newIssuer starts an OIDC provider whose token endpoint returns an ID token
+// carrying the nonce stored in the issuer.
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: privateKey, KeyID: "_kid_"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := &issuer{subject: "google-oauth2|1234"}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		metadata := map[string]interface{}{
+			"issuer":                                i.URL,
+			"authorization_endpoint":                i.URL + "/authorize",
+			"token_endpoint":                        i.URL + "/token",
+			"jwks_uri":                              i.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		}
+
+		if i.endSession {
+			metadata["end_session_endpoint"] = i.URL + "/logout"
+		}
+
+		_ = json.NewEncoder(w).Encode(metadata)
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: privateKey.Public(), KeyID: "_kid_", Algorithm: "RS256", Use: "sig"}}})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"iss":   i.URL,
+			"aud":   "_client_id_",
+			"sub":   i.subject,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": i.nonce,
+			"name":  "_name_",
+		})
+
+		jws, err := signer.Sign(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		idToken, _ := jws.CompactSerialize()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "_access_token_",
+			"token_type":   "bearer",
+			"id_token":     idToken,
+		})
+	})
+
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+
+	return i
+}
+
+func newOIDCConfig(issuerURL string) Config {
+	config := newConfig()
+	config.IssuerURL = issuerURL
+
+	return config
+}
+
+func TestAuthenticator_CreateAuthentication_OIDCNonce(t *testing.T) {
+	// Given
+	i := newIssuer(t)
+
+	a, err := NewAuthenticator(newOIDCConfig(i.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	authentication, err := a.CreateAuthentication("google")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	u, err := url.Parse(authentication.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	require.NotEmpty(t, authentication.Nonce)
+	require.Equal(t, authentication.Nonce, u.Query().Get("nonce"))
+}
+
+func TestAuthenticator_VerifyAuthentication_OIDC(t *testing.T) {
+	// Given
+	i := newIssuer(t)
+	i.nonce = "_nonce_"
+
+	a, err := NewAuthenticator(newOIDCConfig(i.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	identity, err := a.VerifyAuthentication(context.Background(), Authentication{Provider: "google", Nonce: "_nonce_"}, "_code_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, "google", identity.Provider)
+	require.Equal(t, "google-oauth2|1234", identity.Subject)
+	require.NotEmpty(t, identity.RawIDToken)
+}
+
+func TestAuthenticator_VerifyAuthentication_OIDCAllowedSubject(t *testing.T) {
+	// Given
+	i := newIssuer(t)
+	i.nonce = "_nonce_"
+
+	config := newOIDCConfig(i.URL)
+	config.AllowedSubjectPrefixes = []string{"windowslive|", "google-oauth2|"}
+
+	a, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	identity, err := a.VerifyAuthentication(context.Background(), Authentication{Provider: "google", Nonce: "_nonce_"}, "_code_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, "google-oauth2|1234", identity.Subject)
+}
+
+func TestAuthenticator_VerifyAuthentication_OIDCUnexpectedConnectionError(t *testing.T) {
+	// Given
+	i := newIssuer(t)
+	i.nonce = "_nonce_"
+	i.subject = "auth0|1234"
+
+	config := newOIDCConfig(i.URL)
+	config.AllowedSubjectPrefixes = []string{"google-oauth2|"}
+
+	a, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	_, err = a.VerifyAuthentication(context.Background(), Authentication{Provider: "google", Nonce: "_nonce_"}, "_code_")
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.True(t, errors.Is(err, ErrAuthenticationFailed))
+	require.EqualError(t, err, "auth: authentication failed: subject of an unexpected connection: got: (auth0|1234), want: (google-oauth2|)")
+}
+
+func TestAuthenticator_VerifyAuthentication_OIDCNonceMismatchError(t *testing.T) {
+	tt := []struct {
+		name         string
+		tokenNonce   string
+		sessionNonce string
+	}{
+		{
+			name:         "different nonce",
+			tokenNonce:   "_nonce_",
+			sessionNonce: "_another_nonce_",
+		},
+		{
+			name:         "missing token nonce",
+			sessionNonce: "_nonce_",
+		},
+		{
+			name:       "missing session nonce",
+			tokenNonce: "_nonce_",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			i := newIssuer(t)
+			i.nonce = tc.tokenNonce
+
+			a, err := NewAuthenticator(newOIDCConfig(i.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			_, err = a.VerifyAuthentication(context.Background(), Authentication{Provider: "google", Nonce: tc.sessionNonce}, "_code_")
+
+			// Then
+			require.True(t, errors.Is(err, ErrAuthenticationFailed))
+		})
+	}
+}
+
+func TestAuthenticator_EndSessionURL_OIDC(t *testing.T) {
+	// Given
+	i := newIssuer(t)
+	i.endSession = true
+
+	a, err := NewAuthenticator(newOIDCConfig(i.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	endSessionURL, err := a.EndSessionURL("google", "_id_token_", "https://app.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	u, err := url.Parse(endSessionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	require.Equal(t, i.URL+"/logout", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal(t, "_id_token_", u.Query().Get("id_token_hint"))
+	require.Equal(t, "https://app.example.com", u.Query().Get("post_logout_redirect_uri"))
+	require.Equal(t, "_client_id_", u.Query().Get("client_id"))
+}
+
+func TestAuthenticator_EndSessionURL_Auth0Fallback(t *testing.T) {
+	// Given
+	i := newIssuer(t)
+
+	config := newOIDCConfig(i.URL)
+	config.Auth0 = true
+
+	a, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	endSessionURL, err := a.EndSessionURL("google", "_id_token_", "https://app.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	u, err := url.Parse(endSessionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	require.Equal(t, i.URL+"/v2/logout", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal(t, "https://app.example.com", u.Query().Get("returnTo"))
+	require.Equal(t, "_client_id_", u.Query().Get("client_id"))
+}
+
+func TestAuthenticator_EndSessionURL_NoEndSessionEndpoint(t *testing.T) {
+	// Given
+	i := newIssuer(t)
+
+	a, err := NewAuthenticator(newOIDCConfig(i.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	endSessionURL, err := a.EndSessionURL("google", "_id_token_", "https://app.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Empty(t, endSessionURL)
+}
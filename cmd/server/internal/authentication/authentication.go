@@ -6,11 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/auth"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/client"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/email"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/hook"
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/jwt"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/refresh"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/role"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/user"
 
-	"github.com/coreos/go-oidc/v3/oidc"
+	gojwt "github.com/golang-jwt/jwt/v4"
+	"gopkg.in/square/go-jose.v2"
 )
 
 var (
@@ -18,77 +26,867 @@ var (
 	ErrVerification = errors.New("authentication: could not verify resource")
 	ErrCreation     = errors.New("authentication: could not create resource")
 	ErrParse        = errors.New("authentication: could not parse resource")
+	ErrRevoked      = errors.New("authentication: resource has been revoked")
+	ErrScope        = errors.New("authentication: scope is not allowed")
+	ErrLinkRequired = errors.New("authentication: identity must be linked")
+	ErrForbidden    = errors.New("authentication: sign in is not allowed")
+	ErrNotOwner     = errors.New("authentication: token belongs to another party")
 )
 
+// ForbiddenError is returned when a user is not allowed to sign in. Reason is
+// a code telling why, e.g. email.ReasonDomainNotAllowed or
+// hook.ReasonSuspended.
+type ForbiddenError struct {
+	Reason string
+}
+
+func (e *ForbiddenError) Error() string {
+	return "sign in is not allowed: " + e.Reason
+}
+
+func (e *ForbiddenError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// PendingLink is an identity that logged in for the first time with Email,
+// the verified email of an existing user, waiting to be linked to that user.
+type PendingLink struct {
+	Identity user.Identity
+	Email    string
+}
+
+// LinkRequiredError is returned when an identity logs in for the first time
+// with the verified email of an existing user. Link is linked to that user
+// once it logs in again with Provider, through Service.LinkAuthentication.
+type LinkRequiredError struct {
+	Provider string
+	Link     PendingLink
+}
+
+func (e *LinkRequiredError) Error() string {
+	return fmt.Sprintf("log in with %s to link your %s account", e.Provider, e.Link.Identity.Provider)
+}
+
+func (e *LinkRequiredError) Is(target error) bool {
+	return target == ErrLinkRequired
+}
+
 type Authenticator interface {
-	CreateAuthentication() (uri, state string, err error)
-	VerifyAuthentication(ctx context.Context, code string) (idToken *oidc.IDToken, err error)
+	CreateAuthentication(provider string) (auth.Authentication, error)
+	VerifyAuthentication(ctx context.Context, authentication auth.Authentication, code string) (*auth.Identity, error)
+	EndSessionURL(provider, idTokenHint, postLogoutRedirectURI string) (string, error)
 }
 
+type Authentication = auth.Authentication
+
 type JWT interface {
-	Create(v jwt.UnmarshalClaims, subject string) (string, error)
-	Claims(signedToken string) (jwt.Claims, error)
+	Create(v jwt.UnmarshalClaims, subject string, roles []string, permissions []string, extra map[string]interface{}) (string, error)
+	CreateForClient(clientID string, scopes []string) (string, error)
+	RenewableClaims(signedToken string) (jwt.CClaims, error)
+	Renew(claims jwt.CClaims) (string, error)
+	Revoke(signedToken string) error
+	Claims(signedToken string) (jwt.CClaims, error)
+	KeySet() jose.JSONWebKeySet
+}
+
+type Refresher interface {
+	Create(accessToken string) (string, error)
+	Lookup(refreshToken string) (refresh.Token, error)
+	Rotate(refreshToken string, accessToken string) (string, error)
+	Revoke(refreshToken string) error
+}
+
+type RoleResolver interface {
+	Resolve(v role.UnmarshalClaims) (role.Access, error)
+}
+
+type EmailPolicy interface {
+	Evaluate(v email.UnmarshalClaims) error
+}
+
+// Hooks run on every login before the token of the user is created, e.g. a
+// hook.Chain.
+type Hooks interface {
+	Run(ctx context.Context, login *hook.Login) error
+}
+
+type ClientRegistry interface {
+	Authenticate(id string, secret string) (client.Client, error)
+}
+
+type UserRepository interface {
+	Get(id string) (user.User, error)
+	FindByIdentity(identity user.Identity) (user.User, error)
+	FindByEmail(email string) (user.User, error)
+	Upsert(user user.User) (user.User, error)
+}
+
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope,omitempty"`
+	// IDToken is the raw upstream ID token, kept only to be sent back as
+	// id_token_hint on logout.
+	IDToken string `json:"-"`
+}
+
+// Introspection is the RFC 7662 response describing a token. Only Active is
+// set for tokens that are invalid, expired or revoked.
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Configuration is the RFC 8414 metadata describing this service as an
+// authorization server. It only advertises what the service supports: users
+// log in through its own login flow rather than an authorization endpoint, so
+// no response type is supported and the client credentials grant is the only
+// grant of the token endpoint.
+type Configuration struct {
+	Issuer                                    string   `json:"issuer"`
+	TokenEndpoint                             string   `json:"token_endpoint"`
+	ResponseTypesSupported                    []string `json:"response_types_supported"`
+	UserInfoEndpoint                          string   `json:"userinfo_endpoint"`
+	JWKSURI                                   string   `json:"jwks_uri"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint"`
+	RevocationEndpoint                        string   `json:"revocation_endpoint"`
+	GrantTypesSupported                       []string `json:"grant_types_supported"`
+	ClaimsSupported                           []string `json:"claims_supported"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported"`
+}
+
+// UserInfo holds the claims returned by the userinfo endpoint, using the OIDC
+// standard claim names.
+type UserInfo struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Picture string `json:"picture,omitempty"`
+}
+
+// Profile is the response of /me, describing who a token was issued to. Roles
+// and Permissions are always present, empty when the token grants none.
+// Provider and the login times are only set for users stored in the user
+// directory.
+type Profile struct {
+	Subject      string        `json:"sub"`
+	Metadata     *jwt.MetaData `json:"metadata,omitempty"`
+	Provider     string        `json:"provider,omitempty"`
+	FirstLoginAt *time.Time    `json:"first_login_at,omitempty"`
+	LastLoginAt  *time.Time    `json:"last_login_at,omitempty"`
+	Roles        []string      `json:"roles"`
+	Permissions  []string      `json:"permissions"`
+	Scope        string        `json:"scope,omitempty"`
+	IssuedAt     int64         `json:"iat"`
+	ExpiresAt    int64         `json:"exp"`
+}
+
+func NewProfile(claims jwt.CClaims) Profile {
+	profile := Profile{
+		Subject:     claims.Subject,
+		Metadata:    claims.Metadata,
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+		Scope:       claims.Scope,
+		IssuedAt:    unix(claims.IssuedAt),
+		ExpiresAt:   unix(claims.ExpiresAt),
+	}
+
+	if profile.Roles == nil {
+		profile.Roles = []string{}
+	}
+
+	if profile.Permissions == nil {
+		profile.Permissions = []string{}
+	}
+
+	return profile
 }
 
 type Service struct {
+	issuer        string
 	authenticator Authenticator
 	jwt           JWT
+	refresher     Refresher
+	clients       ClientRegistry
+	roles         RoleResolver
+	users         UserRepository
+	emails        EmailPolicy
+	hooks         Hooks
 }
 
-func NewService(authenticator Authenticator, jwt JWT) *Service {
+func NewService(issuer string, authenticator Authenticator, jwt JWT, refresher Refresher, clients ClientRegistry, roles RoleResolver, users UserRepository, emails EmailPolicy, hooks Hooks) *Service {
 	return &Service{
+		issuer:        strings.TrimSuffix(issuer, "/"),
 		authenticator: authenticator,
 		jwt:           jwt,
+		refresher:     refresher,
+		clients:       clients,
+		roles:         roles,
+		users:         users,
+		emails:        emails,
+		hooks:         hooks,
 	}
 }
 
-func (s *Service) CreateAuthentication() (url, state string, err error) {
-	return s.authenticator.CreateAuthentication()
+func (s *Service) CreateAuthentication(provider string) (Authentication, error) {
+	authentication, err := s.authenticator.CreateAuthentication(provider)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnsupportedProvider) {
+			return Authentication{}, fmt.Errorf("could not create authentication: %w", ErrNotFound)
+		}
+
+		return Authentication{}, err
+	}
+
+	return authentication, nil
+}
+
+func (s *Service) VerifyAuthentication(ctx context.Context, authentication Authentication, code string) (Tokens, error) {
+	identity, err := s.verifyIdentity(ctx, authentication, code)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	user_, err := s.loginUser(identity)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return s.createTokens(ctx, identity, user_)
 }
 
-func (s *Service) VerifyAuthentication(ctx context.Context, code string) (string, error) {
-	idToken, err := s.authenticator.VerifyAuthentication(ctx, code)
+// LinkAuthentication verifies the login of a user that is already known and
+// links to it the identity of link, whose login returned a LinkRequiredError.
+// The user must be the one owning the verified email of link.
+func (s *Service) LinkAuthentication(ctx context.Context, authentication Authentication, code string, link PendingLink) (Tokens, error) {
+	identity, err := s.verifyIdentity(ctx, authentication, code)
 	if err != nil {
-		switch err {
-		case auth.ErrNotFound:
-			return "", fmt.Errorf("could not verify authentication: %w", ErrNotFound)
-		case auth.ErrAuthenticationFailed:
-			return "", fmt.Errorf("could not verify authentication: %w", ErrVerification)
+		return Tokens{}, err
+	}
+
+	user_, err := s.users.FindByIdentity(user.Identity{Provider: identity.Provider, Subject: identity.Subject})
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return Tokens{}, fmt.Errorf("could not find user to link: %w", ErrVerification)
 		}
 
-		return "", fmt.Errorf("could not verify authentication: %v", err)
+		return Tokens{}, fmt.Errorf("could not find user to link: %v", err)
+	}
+
+	owner, err := s.users.FindByEmail(link.Email)
+	switch {
+	case errors.Is(err, user.ErrNotFound), err == nil && owner.ID != user_.ID:
+		return Tokens{}, fmt.Errorf("could not link identity of another email: %w", ErrVerification)
+	case err != nil:
+		return Tokens{}, fmt.Errorf("could not find user to link: %v", err)
+	}
+
+	linked, err := s.users.FindByIdentity(link.Identity)
+	switch {
+	case err == nil && linked.ID != user_.ID:
+		return Tokens{}, fmt.Errorf("could not link identity of another user: %w", ErrVerification)
+	case err != nil && !errors.Is(err, user.ErrNotFound):
+		return Tokens{}, fmt.Errorf("could not find user to link: %v", err)
 	}
 
-	token, err := s.jwt.Create(idToken, idToken.Subject)
+	login, err := s.newUser(identity)
 	if err != nil {
-		if errors.Is(err, jwt.ErrNotFound) || errors.Is(err, jwt.ErrUnsupportedProvider) {
-			return "", fmt.Errorf("could not create token: %w", ErrCreation)
+		return Tokens{}, err
+	}
+
+	login.ID = user_.ID
+	login.FirstLoginAt = user_.FirstLoginAt
+	login.Identities = user_.Link(link.Identity).Identities
+
+	return s.createTokens(ctx, identity, login)
+}
+
+// verifyIdentity verifies the login of a user and that its email is allowed
+// to sign in.
+func (s *Service) verifyIdentity(ctx context.Context, authentication Authentication, code string) (*auth.Identity, error) {
+	identity, err := s.authenticator.VerifyAuthentication(ctx, authentication, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrNotFound), errors.Is(err, auth.ErrUnsupportedProvider):
+			return nil, fmt.Errorf("could not verify authentication: %w", ErrNotFound)
+		case errors.Is(err, auth.ErrAuthenticationFailed):
+			return nil, fmt.Errorf("could not verify authentication: %w", ErrVerification)
 		}
 
-		return "", fmt.Errorf("could not create token: %v", err)
+		return nil, fmt.Errorf("could not verify authentication: %v", err)
 	}
 
-	return token, nil
+	if err := s.evaluateEmail(identity); err != nil {
+		return nil, err
+	}
+
+	return identity, nil
 }
 
-func (s *Service) GetMyInformation(token string) ([]byte, error) {
-	sToken := strings.Split(token, " ")
-	if len(sToken) != 2 {
-		return nil, fmt.Errorf("invalid token length: %w", ErrParse)
+// evaluateEmail tells whether the email of v is allowed to sign in.
+func (s *Service) evaluateEmail(v email.UnmarshalClaims) error {
+	if err := s.emails.Evaluate(v); err != nil {
+		var deniedErr *email.DeniedError
+		if errors.As(err, &deniedErr) {
+			return fmt.Errorf("could not verify email: %w", &ForbiddenError{Reason: deniedErr.Reason})
+		}
+
+		return fmt.Errorf("could not verify email: %v", err)
 	}
 
-	claims, err := s.jwt.Claims(sToken[1])
+	return nil
+}
+
+// loginUser returns the user identity logs in as, updated with this login. It
+// is saved by createTokens once the hooks let it in. An identity logging in for
+// the first time with the verified email of another user gets a
+// LinkRequiredError instead, so that the user links it by logging in with the
+// provider it already uses.
+func (s *Service) loginUser(identity *auth.Identity) (user.User, error) {
+	login, err := s.newUser(identity)
 	if err != nil {
-		if errors.Is(err, jwt.ErrMalformedToken) || errors.Is(err, jwt.ErrExpiredToken) {
-			return nil, fmt.Errorf("could not fetch claims: %v", ErrCreation)
+		return user.User{}, err
+	}
+
+	user_, err := s.users.FindByIdentity(user.Identity{Provider: identity.Provider, Subject: identity.Subject})
+	switch {
+	case err == nil:
+		login.ID = user_.ID
+		login.FirstLoginAt = user_.FirstLoginAt
+		login.Identities = user_.Identities
+	case !errors.Is(err, user.ErrNotFound):
+		return user.User{}, fmt.Errorf("could not find user: %v", err)
+	case login.EmailVerified && login.Email != "":
+		user_, err := s.users.FindByEmail(login.Email)
+		if err == nil {
+			return user.User{}, &LinkRequiredError{
+				Provider: user_.Provider,
+				Link:     PendingLink{Identity: login.Identities[0], Email: login.Email},
+			}
 		}
 
-		return nil, err
+		if !errors.Is(err, user.ErrNotFound) {
+			return user.User{}, fmt.Errorf("could not find user: %v", err)
+		}
+	}
+
+	return login, nil
+}
+
+// profileClaims are the claims of the profile of a user, standing for its
+// identity when there is none, like on refresh. They use the OIDC standard
+// claim names of an ID token, the subject being the one of the identity the
+// user first logged in with.
+type profileClaims user.User
+
+func (p profileClaims) Claims(v interface{}) error {
+	claims := struct {
+		Subject       string `json:"sub,omitempty"`
+		Name          string `json:"name,omitempty"`
+		Email         string `json:"email,omitempty"`
+		EmailVerified bool   `json:"email_verified"`
+		Picture       string `json:"picture,omitempty"`
+	}{
+		Name:          p.Name,
+		Email:         p.Email,
+		EmailVerified: p.EmailVerified,
+		Picture:       p.AvatarURL,
+	}
+
+	if len(p.Identities) > 0 {
+		claims.Subject = p.Identities[0].Subject
 	}
 
 	b, err := json.Marshal(claims)
 	if err != nil {
-		return nil, fmt.Errorf("could not marshal claims: %v", err)
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func (s *Service) newUser(identity *auth.Identity) (user.User, error) {
+	user_, err := user.New(identity.Provider, identity, time.Now())
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return user.User{}, fmt.Errorf("could not create user: %w", ErrCreation)
+		}
+
+		return user.User{}, fmt.Errorf("could not create user: %v", err)
+	}
+
+	return user_, nil
+}
+
+// createTokens records the login of user_, who logged in with identity, and
+// issues its tokens once the hooks let it in.
+func (s *Service) createTokens(ctx context.Context, identity *auth.Identity, user_ user.User) (Tokens, error) {
+	access, err := s.roles.Resolve(identity)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not resolve roles: %v", err)
+	}
+
+	login := hook.Login{
+		Identity:    identity,
+		User:        user_,
+		Roles:       access.Roles,
+		Permissions: access.Permissions,
+	}
+
+	if err := s.runHooks(ctx, &login); err != nil {
+		return Tokens{}, err
+	}
+
+	if user_, err = s.users.Upsert(user_); err != nil {
+		return Tokens{}, fmt.Errorf("could not save user: %v", err)
+	}
+
+	token, err := s.jwt.Create(identity, user_.ID, login.Roles, login.Permissions, login.Claims)
+	if err != nil {
+		if errors.Is(err, jwt.ErrNotFound) {
+			return Tokens{}, fmt.Errorf("could not create token: %w", ErrCreation)
+		}
+
+		return Tokens{}, fmt.Errorf("could not create token: %v", err)
+	}
+
+	refreshToken, err := s.refresher.Create(token)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not create refresh token: %v", err)
+	}
+
+	tokens := newTokens(token, refreshToken)
+	tokens.IDToken = identity.RawIDToken
+
+	return tokens, nil
+}
+
+// runHooks runs the hooks on login, turning their denial into a
+// ForbiddenError.
+func (s *Service) runHooks(ctx context.Context, login *hook.Login) error {
+	if err := s.hooks.Run(ctx, login); err != nil {
+		var deniedErr *hook.DeniedError
+		if errors.As(err, &deniedErr) {
+			return fmt.Errorf("could not run hooks: %w", &ForbiddenError{Reason: deniedErr.Reason})
+		}
+
+		return fmt.Errorf("could not run hooks: %v", err)
+	}
+
+	return nil
+}
+
+// Refresh renews the access token bound to refreshToken and rotates it. The
+// user must still be allowed to sign in, so the email policy and the hooks run
+// again against its profile, starting from the roles and permissions of the
+// renewed token.
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	storedToken, err := s.refresher.Lookup(refreshToken)
+	if err != nil {
+		if isInvalidRefreshToken(err) {
+			return Tokens{}, fmt.Errorf("could not find refresh token: %w", ErrVerification)
+		}
+
+		return Tokens{}, fmt.Errorf("could not find refresh token: %v", err)
+	}
+
+	claims, err := s.jwt.RenewableClaims(storedToken.AccessToken)
+	if err != nil {
+		if errors.Is(err, jwt.ErrMalformedToken) {
+			return Tokens{}, fmt.Errorf("could not renew token: %w", ErrVerification)
+		}
+
+		return Tokens{}, fmt.Errorf("could not renew token: %v", err)
+	}
+
+	user_, err := s.users.Get(claims.Subject)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return Tokens{}, fmt.Errorf("could not get user: %w", ErrVerification)
+		}
+
+		return Tokens{}, fmt.Errorf("could not get user: %v", err)
+	}
+
+	if err := s.evaluateEmail(profileClaims(user_)); err != nil {
+		return Tokens{}, err
+	}
+
+	login := hook.Login{
+		Identity:    profileClaims(user_),
+		User:        user_,
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+	}
+
+	if err := s.runHooks(ctx, &login); err != nil {
+		return Tokens{}, err
+	}
+
+	claims.Roles = login.Roles
+	claims.Permissions = login.Permissions
+	claims.Extra = login.Claims
+
+	token, err := s.jwt.Renew(claims)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not renew token: %v", err)
+	}
+
+	newRefreshToken, err := s.refresher.Rotate(refreshToken, token)
+	if err != nil {
+		if isInvalidRefreshToken(err) {
+			return Tokens{}, fmt.Errorf("could not rotate refresh token: %w", ErrVerification)
+		}
+
+		return Tokens{}, fmt.Errorf("could not rotate refresh token: %v", err)
+	}
+
+	return newTokens(token, newRefreshToken), nil
+}
+
+func (s *Service) Logout(token string, refreshToken string) error {
+	if token != "" {
+		if err := s.jwt.Revoke(token); err != nil && !errors.Is(err, jwt.ErrMalformedToken) {
+			return fmt.Errorf("could not revoke token: %v", err)
+		}
+	}
+
+	if refreshToken != "" {
+		if err := s.refresher.Revoke(refreshToken); err != nil && !errors.Is(err, refresh.ErrNotFound) {
+			return fmt.Errorf("could not revoke refresh token: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// EndSession returns the URL that ends the user session at the provider, or an
+// empty string when the provider does not support RP-initiated logout.
+func (s *Service) EndSession(provider string, idToken string, postLogoutRedirectURI string) (string, error) {
+	endSessionURL, err := s.authenticator.EndSessionURL(provider, idToken, postLogoutRedirectURI)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnsupportedProvider) {
+			return "", fmt.Errorf("could not end session: %w", ErrNotFound)
+		}
+
+		return "", fmt.Errorf("could not end session: %v", err)
+	}
+
+	return endSessionURL, nil
+}
+
+// ValidateToken verifies token and returns its claims.
+func (s *Service) ValidateToken(token string) (jwt.CClaims, error) {
+	claims, err := s.jwt.Claims(token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrMalformedToken) || errors.Is(err, jwt.ErrExpiredToken) || errors.Is(err, jwt.ErrAudience) {
+			return jwt.CClaims{}, fmt.Errorf("could not fetch claims: %w", ErrVerification)
+		}
+
+		if errors.Is(err, jwt.ErrRevokedToken) {
+			return jwt.CClaims{}, fmt.Errorf("could not fetch claims: %w", ErrRevoked)
+		}
+
+		return jwt.CClaims{}, fmt.Errorf("could not fetch claims: %v", err)
+	}
+
+	return claims, nil
+}
+
+// unix returns date in seconds since the epoch, or zero when it is unset.
+func unix(date *gojwt.NumericDate) int64 {
+	if date == nil {
+		return 0
+	}
+
+	return date.Unix()
+}
+
+// GetProfile describes who the token with claims was issued to, preferring the
+// data stored in the user directory over the claims, which may be stale.
+func (s *Service) GetProfile(claims jwt.CClaims) (Profile, error) {
+	profile := NewProfile(claims)
+
+	user_, err := s.users.Get(claims.Subject)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return profile, nil
+		}
+
+		return Profile{}, fmt.Errorf("could not get user: %v", err)
+	}
+
+	profile.Metadata = &jwt.MetaData{
+		Name:      user_.Name,
+		Email:     user_.Email,
+		AvatarURL: user_.AvatarURL,
+	}
+	profile.Provider = user_.Provider
+	profile.FirstLoginAt = &user_.FirstLoginAt
+	profile.LastLoginAt = &user_.LastLoginAt
+
+	return profile, nil
+}
+
+func (s *Service) GetUser(id string) (user.User, error) {
+	user_, err := s.users.Get(id)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return user.User{}, fmt.Errorf("could not get user: %w", ErrNotFound)
+		}
+
+		return user.User{}, fmt.Errorf("could not get user: %v", err)
+	}
+
+	return user_, nil
+}
+
+func newTokens(accessToken string, refreshToken string) Tokens {
+	return Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+	}
+}
+
+func isInvalidRefreshToken(err error) bool {
+	return errors.Is(err, refresh.ErrNotFound) || errors.Is(err, refresh.ErrExpiredToken) || errors.Is(err, refresh.ErrReusedToken)
+}
+
+// CreateClientToken issues an access token to the client authenticated by
+// clientID and clientSecret, as in the OAuth client credentials grant. scope
+// holds the requested scopes separated by spaces; when empty, every scope
+// allowed to the client is granted.
+func (s *Service) CreateClientToken(clientID string, clientSecret string, scope string) (Tokens, error) {
+	c, err := s.clients.Authenticate(clientID, clientSecret)
+	if err != nil {
+		if errors.Is(err, client.ErrInvalidCredentials) {
+			return Tokens{}, fmt.Errorf("could not authenticate client: %w", ErrVerification)
+		}
+
+		return Tokens{}, fmt.Errorf("could not authenticate client: %v", err)
+	}
+
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		scopes = c.Scopes
+	}
+
+	if !c.AllowsScopes(scopes) {
+		return Tokens{}, fmt.Errorf("could not grant scope: %w", ErrScope)
+	}
+
+	token, err := s.jwt.CreateForClient(c.ID, scopes)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not create token: %v", err)
+	}
+
+	tokens := newTokens(token, "")
+	tokens.Scope = strings.Join(scopes, " ")
+
+	return tokens, nil
+}
+
+// Introspect describes token to the client authenticated by clientID and
+// clientSecret, so resource servers do not need the signing keys.
+func (s *Service) Introspect(clientID string, clientSecret string, token string) ([]byte, error) {
+	if _, err := s.clients.Authenticate(clientID, clientSecret); err != nil {
+		if errors.Is(err, client.ErrInvalidCredentials) {
+			return nil, fmt.Errorf("could not authenticate client: %w", ErrVerification)
+		}
+
+		return nil, fmt.Errorf("could not authenticate client: %v", err)
+	}
+
+	introspection, err := s.introspect(token)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(introspection)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal introspection: %v", err)
+	}
+
+	return b, nil
+}
+
+func (s *Service) introspect(token string) (Introspection, error) {
+	claims, err := s.jwt.Claims(token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrMalformedToken) || errors.Is(err, jwt.ErrExpiredToken) || errors.Is(err, jwt.ErrAudience) || errors.Is(err, jwt.ErrRevokedToken) {
+			return Introspection{Active: false}, nil
+		}
+
+		return Introspection{}, fmt.Errorf("could not fetch claims: %v", err)
+	}
+
+	introspection := Introspection{
+		Active:    true,
+		Scope:     strings.Join(claims.Scopes(), " "),
+		TokenType: "Bearer",
+		ExpiresAt: unix(claims.ExpiresAt),
+		IssuedAt:  unix(claims.IssuedAt),
+		NotBefore: unix(claims.NotBefore),
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		ID:        claims.ID,
+	}
+
+	// Tokens issued to clients have no upstream identity and the client as
+	// their subject.
+	if claims.Upstream == nil {
+		introspection.ClientID = claims.Subject
+	}
+
+	return introspection, nil
+}
+
+// RevokeToken revokes an access or refresh token on behalf of the client
+// authenticated by clientID and clientSecret. Following RFC 7009, tokens that
+// are invalid or unknown are ignored, and tokenTypeHint only decides which
+// kind of token is tried first. A client can only revoke its own access
+// tokens, unless it is allowed to revoke the tokens of users, refresh tokens
+// included. Access tokens of someone else are refused with ErrNotOwner.
+func (s *Service) RevokeToken(clientID string, clientSecret string, token string, tokenTypeHint string) error {
+	c, err := s.clients.Authenticate(clientID, clientSecret)
+	if err != nil {
+		if errors.Is(err, client.ErrInvalidCredentials) {
+			return fmt.Errorf("could not authenticate client: %w", ErrVerification)
+		}
+
+		return fmt.Errorf("could not authenticate client: %v", err)
+	}
+
+	revokers := []func(c client.Client, token string) (bool, error){s.revokeAccessToken, s.revokeRefreshToken}
+	if tokenTypeHint == "refresh_token" && c.RevokesUserTokens {
+		revokers[0], revokers[1] = revokers[1], revokers[0]
+	}
+
+	for _, revoke := range revokers {
+		revoked, err := revoke(c, token)
+		if err != nil {
+			return err
+		}
+
+		if revoked {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) revokeAccessToken(c client.Client, token string) (bool, error) {
+	claims, err := s.jwt.RenewableClaims(token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrMalformedToken) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("could not fetch claims: %v", err)
+	}
+
+	// Tokens of users carry their upstream identity, tokens of clients do not.
+	owned := claims.Upstream == nil && claims.Subject == c.ID
+	if claims.Upstream != nil {
+		owned = c.RevokesUserTokens
+	}
+
+	if !owned {
+		return false, fmt.Errorf("could not revoke token: %w", ErrNotOwner)
+	}
+
+	if err := s.jwt.Revoke(token); err != nil {
+		if errors.Is(err, jwt.ErrMalformedToken) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("could not revoke token: %v", err)
+	}
+
+	return true, nil
+}
+
+// revokeRefreshToken revokes token as a refresh token. Refresh tokens are only
+// issued to users, so for clients not allowed to revoke the tokens of users
+// token is ignored like an unknown one, without looking it up.
+func (s *Service) revokeRefreshToken(c client.Client, token string) (bool, error) {
+	if !c.RevokesUserTokens {
+		return false, nil
+	}
+
+	if err := s.refresher.Revoke(token); err != nil {
+		if errors.Is(err, refresh.ErrNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("could not revoke refresh token: %v", err)
+	}
+
+	return true, nil
+}
+
+// GetUserInfo returns the OIDC claims of the user claims were issued to.
+func (s *Service) GetUserInfo(claims jwt.CClaims) ([]byte, error) {
+	userInfo := UserInfo{Subject: claims.Subject}
+	if claims.Metadata != nil {
+		userInfo.Name = claims.Metadata.Name
+		userInfo.Email = claims.Metadata.Email
+		userInfo.Picture = claims.Metadata.AvatarURL
+	}
+
+	b, err := json.Marshal(userInfo)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal user info: %v", err)
+	}
+
+	return b, nil
+}
+
+// GetConfiguration returns the authorization server metadata of this service.
+func (s *Service) GetConfiguration() ([]byte, error) {
+	authMethods := []string{"client_secret_basic", "client_secret_post"}
+
+	b, err := json.Marshal(Configuration{
+		Issuer:                            s.issuer,
+		TokenEndpoint:                     s.issuer + "/oauth/token",
+		ResponseTypesSupported:            []string{},
+		UserInfoEndpoint:                  s.issuer + "/userinfo",
+		JWKSURI:                           s.issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             s.issuer + "/oauth/introspect",
+		RevocationEndpoint:                s.issuer + "/oauth/revoke",
+		GrantTypesSupported:               []string{"client_credentials"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nbf", "name", "email", "picture"},
+		TokenEndpointAuthMethodsSupported: authMethods,
+		IntrospectionEndpointAuthMethodsSupported: authMethods,
+		RevocationEndpointAuthMethodsSupported:    authMethods,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal configuration: %v", err)
+	}
+
+	return b, nil
+}
+
+func (s *Service) GetKeySet() ([]byte, error) {
+	b, err := json.Marshal(s.jwt.KeySet())
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal key set: %v", err)
 	}
 
 	return b, nil
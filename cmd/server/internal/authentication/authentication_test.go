@@ -2,74 +2,135 @@ package authentication
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/auth"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/client"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/email"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/hook"
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/jwt"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/refresh"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/role"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/user"
 
-	"github.com/coreos/go-oidc/v3/oidc"
+	gojwt "github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
 )
 
 type authenticatorMock struct {
 	mock.Mock
 }
 
-func (a *authenticatorMock) CreateAuthentication() (uri, state string, err error) {
-	args := a.Called()
-	return args.String(0), args.String(1), args.Error(2)
+func (a *authenticatorMock) CreateAuthentication(provider string) (auth.Authentication, error) {
+	args := a.Called(provider)
+	return args.Get(0).(auth.Authentication), args.Error(1)
 }
 
-func (a *authenticatorMock) VerifyAuthentication(ctx context.Context, code string) (idToken *oidc.IDToken, err error) {
-	args := a.Called(ctx, code)
-	return args.Get(0).(*oidc.IDToken), args.Error(1)
+func (a *authenticatorMock) VerifyAuthentication(ctx context.Context, authentication auth.Authentication, code string) (*auth.Identity, error) {
+	args := a.Called(ctx, authentication, code)
+	return args.Get(0).(*auth.Identity), args.Error(1)
+}
+
+func (a *authenticatorMock) EndSessionURL(provider, idTokenHint, postLogoutRedirectURI string) (string, error) {
+	args := a.Called(provider, idTokenHint, postLogoutRedirectURI)
+	return args.String(0), args.Error(1)
 }
 
 type jwtMock struct {
 	mock.Mock
 }
 
-func (j *jwtMock) Create(v jwt.UnmarshalClaims, subject string) (string, error) {
-	args := j.Called(v, subject)
+func (j *jwtMock) Create(v jwt.UnmarshalClaims, subject string, roles []string, permissions []string, extra map[string]interface{}) (string, error) {
+	args := j.Called(v, subject, roles, permissions, extra)
+	return args.String(0), args.Error(1)
+}
+
+func (j *jwtMock) CreateForClient(clientID string, scopes []string) (string, error) {
+	args := j.Called(clientID, scopes)
+	return args.String(0), args.Error(1)
+}
+
+func (j *jwtMock) RenewableClaims(signedToken string) (jwt.CClaims, error) {
+	args := j.Called(signedToken)
+	return args.Get(0).(jwt.CClaims), args.Error(1)
+}
+
+func (j *jwtMock) Renew(claims jwt.CClaims) (string, error) {
+	args := j.Called(claims)
 	return args.String(0), args.Error(1)
 }
 
-func (j *jwtMock) Claims(signedToken string) (jwt.Claims, error) {
+func (j *jwtMock) Revoke(signedToken string) error {
 	args := j.Called(signedToken)
-	return args.Get(0).(jwt.Claims), args.Error(1)
+	return args.Error(0)
+}
+
+func (j *jwtMock) Claims(signedToken string) (jwt.CClaims, error) {
+	args := j.Called(signedToken)
+	return args.Get(0).(jwt.CClaims), args.Error(1)
+}
+
+func (j *jwtMock) KeySet() jose.JSONWebKeySet {
+	args := j.Called()
+	return args.Get(0).(jose.JSONWebKeySet)
+}
+
+type refresherMock struct {
+	mock.Mock
+}
+
+func (r *refresherMock) Create(accessToken string) (string, error) {
+	args := r.Called(accessToken)
+	return args.String(0), args.Error(1)
+}
+
+func (r *refresherMock) Lookup(refreshToken string) (refresh.Token, error) {
+	args := r.Called(refreshToken)
+	return args.Get(0).(refresh.Token), args.Error(1)
+}
+
+func (r *refresherMock) Rotate(refreshToken string, accessToken string) (string, error) {
+	args := r.Called(refreshToken, accessToken)
+	return args.String(0), args.Error(1)
 }
 
 func TestService_CreateAuthentication(t *testing.T) {
 	// Given
 	jwt_ := jwtMock{}
+	refresher_ := refresherMock{}
 	authenticator := authenticatorMock{}
-	authenticator.On("CreateAuthentication").Return("uri", "state", nil)
+	authenticator.On("CreateAuthentication", "google").Return(auth.Authentication{URL: "uri", State: "state"}, nil)
 
-	s := NewService(&authenticator, &jwt_)
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
 
 	// When
-	uri, state, err := s.CreateAuthentication()
+	authentication, err := s.CreateAuthentication("google")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Then
-	require.Equal(t, "uri", uri)
-	require.Equal(t, "state", state)
+	require.Equal(t, Authentication{URL: "uri", State: "state"}, authentication)
 }
 
 func TestService_CreateAuthentication_Error(t *testing.T) {
 	// Given
 	jwt_ := jwtMock{}
+	refresher_ := refresherMock{}
 	authenticator := authenticatorMock{}
-	authenticator.On("CreateAuthentication").Return("", "", errors.New("error"))
+	authenticator.On("CreateAuthentication", "google").Return(auth.Authentication{}, errors.New("error"))
 
-	s := NewService(&authenticator, &jwt_)
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
 
 	// When
-	_, _, err := s.CreateAuthentication()
+	_, err := s.CreateAuthentication("google")
 	if err == nil {
 		t.Fatal("test must fail")
 	}
@@ -78,50 +139,182 @@ func TestService_CreateAuthentication_Error(t *testing.T) {
 	require.EqualError(t, err, "error")
 }
 
+func TestService_CreateAuthentication_UnsupportedProviderError(t *testing.T) {
+	// Given
+	jwt_ := jwtMock{}
+	refresher_ := refresherMock{}
+	authenticator := authenticatorMock{}
+	authenticator.On("CreateAuthentication", "unknown").Return(auth.Authentication{}, auth.ErrUnsupportedProvider)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	_, err := s.CreateAuthentication("unknown")
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "could not create authentication: authentication: resource not found")
+}
+
 func TestService_VerifyAuthentication(t *testing.T) {
 	// Given
 	ctx := context.Background()
-	idToken := &oidc.IDToken{Subject: "google-oauth2"}
+	idToken := newIdentity()
+	idToken.RawIDToken = "_id_token_"
 	code := "_code_"
+	authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
 
 	authenticator := authenticatorMock{}
-	authenticator.On("VerifyAuthentication", ctx, code).Return(idToken, nil)
+	authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", idToken).Return(nil)
+
+	roles := roleResolverMock{}
+	roles.On("Resolve", idToken).Return(role.Access{Roles: []string{"admin"}, Permissions: []string{"courses:write"}}, nil)
 
 	jwt_ := jwtMock{}
-	jwt_.On("Create", idToken, "google-oauth2").Return("token", nil)
+	refresher_ := refresherMock{}
+	jwt_.On("Create", idToken, "_user_id_", []string{"admin"}, []string{"courses:write"}, map[string]interface{}(nil)).Return("token", nil)
+
+	refresher_.On("Create", "token").Return("refresh", nil)
 
-	s := NewService(&authenticator, &jwt_)
+	users := userRepositoryMock{}
+	users.On("FindByIdentity", user.Identity{Provider: "google", Subject: "google-oauth2"}).Return(user.User{}, user.ErrNotFound)
+	users.On("Upsert", mock.MatchedBy(func(u user.User) bool {
+		return u.ID != "" && u.ID != "google-oauth2" && u.Provider == "google" && u.Name == "_name_" && u.FirstLoginAt.Equal(u.LastLoginAt) &&
+			u.HasIdentity(user.Identity{Provider: "google", Subject: "google-oauth2"})
+	})).Return(user.User{ID: "_user_id_"}, nil)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roles, &users, &emails, hook.Chain{})
 
 	// When
-	token, err := s.VerifyAuthentication(ctx, code)
+	tokens, err := s.VerifyAuthentication(ctx, authentication, code)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Then
-	require.Equal(t, "token", token)
+	require.Equal(t, Tokens{AccessToken: "token", RefreshToken: "refresh", TokenType: "Bearer", IDToken: "_id_token_"}, tokens)
+	users.AssertExpectations(t)
 }
 
-func TestService_VerifyAuthentication_VerifyAuthenticationErrors(t *testing.T) {
+func newIdentity() *auth.Identity {
+	identity, _ := auth.NewIdentity("google", []byte(`{"sub":"google-oauth2","name":"_name_","email":"_email_"}`))
+	return identity
+}
+
+func TestService_VerifyAuthentication_LinkedUser(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	idToken, _ := auth.NewIdentity("github", []byte(`{"sub":"github|1234","name":"_name_","email":"_email_","email_verified":true}`))
+	code := "_code_"
+	authentication := Authentication{Provider: "github", State: "_state_", CodeVerifier: "_verifier_"}
+
+	authenticator := authenticatorMock{}
+	authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", idToken).Return(nil)
+
+	roles := roleResolverMock{}
+	roles.On("Resolve", idToken).Return(role.Access{}, nil)
+
+	jwt_ := jwtMock{}
+	jwt_.On("Create", idToken, "_user_id_", []string(nil), []string(nil), map[string]interface{}(nil)).Return("token", nil)
+
+	refresher_ := refresherMock{}
+	refresher_.On("Create", "token").Return("refresh", nil)
+
+	firstLoginAt := time.Unix(1000, 0)
+
+	users := userRepositoryMock{}
+	users.On("FindByIdentity", user.Identity{Provider: "github", Subject: "github|1234"}).Return(user.User{
+		ID:           "_user_id_",
+		Identities:   []user.Identity{{Provider: "google", Subject: "google-oauth2|1234"}, {Provider: "github", Subject: "github|1234"}},
+		FirstLoginAt: firstLoginAt,
+	}, nil)
+	users.On("Upsert", mock.MatchedBy(func(u user.User) bool {
+		return u.ID == "_user_id_" && u.Provider == "github" && u.FirstLoginAt.Equal(firstLoginAt) && len(u.Identities) == 2
+	})).Return(user.User{ID: "_user_id_"}, nil)
+
+	var login hook.Login
+	hooks := hook.Chain{
+		hook.Func(func(ctx context.Context, l *hook.Login) error {
+			login = *l
+			return nil
+		}),
+	}
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roles, &users, &emails, hooks)
+
+	// When
+	tokens, err := s.VerifyAuthentication(ctx, authentication, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, "token", tokens.AccessToken)
+	require.Equal(t, firstLoginAt, login.User.FirstLoginAt)
+	users.AssertExpectations(t)
+}
+
+func TestService_VerifyAuthentication_LinkRequiredError(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	idToken, _ := auth.NewIdentity("github", []byte(`{"sub":"github|1234","email":"_email_","email_verified":true}`))
+	code := "_code_"
+	authentication := Authentication{Provider: "github", State: "_state_", CodeVerifier: "_verifier_"}
+
+	authenticator := authenticatorMock{}
+	authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", idToken).Return(nil)
+
+	users := userRepositoryMock{}
+	users.On("FindByIdentity", user.Identity{Provider: "github", Subject: "github|1234"}).Return(user.User{}, user.ErrNotFound)
+	users.On("FindByEmail", "_email_").Return(user.User{ID: "_user_id_", Provider: "google"}, nil)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &users, &emails, hook.Chain{})
+
+	// When
+	_, err := s.VerifyAuthentication(ctx, authentication, code)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	var linkErr *LinkRequiredError
+	require.True(t, errors.As(err, &linkErr))
+	require.True(t, errors.Is(err, ErrLinkRequired))
+	require.Equal(t, &LinkRequiredError{
+		Provider: "google",
+		Link:     PendingLink{Identity: user.Identity{Provider: "github", Subject: "github|1234"}, Email: "_email_"},
+	}, linkErr)
+	users.AssertNotCalled(t, "Upsert", mock.Anything)
+}
+
+func TestService_VerifyAuthentication_EmailPolicyErrors(t *testing.T) {
 	tt := []struct {
 		name          string
 		returnedError error
 		expectedError string
+		expectedIs    error
 	}{
 		{
-			name:          "generic error",
-			returnedError: errors.New("error"),
-			expectedError: "could not verify authentication: error",
-		},
-		{
-			name:          "not found error",
-			returnedError: auth.ErrNotFound,
-			expectedError: "could not verify authentication: authentication: resource not found",
+			name:          "denied email",
+			returnedError: &email.DeniedError{Reason: email.ReasonDomainNotAllowed},
+			expectedError: "could not verify email: sign in is not allowed: email_domain_not_allowed",
+			expectedIs:    ErrForbidden,
 		},
 		{
-			name:          "authentication error",
-			returnedError: auth.ErrAuthenticationFailed,
-			expectedError: "could not verify authentication: authentication: could not verify resource",
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not verify email: error",
 		},
 	}
 
@@ -129,46 +322,114 @@ func TestService_VerifyAuthentication_VerifyAuthenticationErrors(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			// Given
 			ctx := context.Background()
+			idToken := newIdentity()
 			code := "_code_"
+			authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
 
-			jwt_ := jwtMock{}
 			authenticator := authenticatorMock{}
-			authenticator.On("VerifyAuthentication", ctx, code).Return(&oidc.IDToken{}, tc.returnedError)
+			authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+			emails := emailPolicyMock{}
+			emails.On("Evaluate", idToken).Return(tc.returnedError)
 
-			s := NewService(&authenticator, &jwt_)
+			users := userRepositoryMock{}
+
+			s := NewService("https://auth.example.com", &authenticator, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &users, &emails, hook.Chain{})
 
 			// When
-			_, err := s.VerifyAuthentication(ctx, code)
+			_, err := s.VerifyAuthentication(ctx, authentication, code)
 			if err == nil {
 				t.Fatal("test must fail")
 			}
 
 			// Then
 			require.EqualError(t, err, tc.expectedError)
+			if tc.expectedIs != nil {
+				var forbiddenErr *ForbiddenError
+				require.True(t, errors.As(err, &forbiddenErr))
+				require.True(t, errors.Is(err, tc.expectedIs))
+				require.Equal(t, email.ReasonDomainNotAllowed, forbiddenErr.Reason)
+			}
+
+			users.AssertNotCalled(t, "FindByIdentity", mock.Anything)
 		})
 	}
 }
 
-func TestService_VerifyAuthentication_JWTCreateTokenErrors(t *testing.T) {
+func TestService_VerifyAuthentication_Hooks(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	idToken := newIdentity()
+	code := "_code_"
+	authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
+
+	authenticator := authenticatorMock{}
+	authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", idToken).Return(nil)
+
+	roles := roleResolverMock{}
+	roles.On("Resolve", idToken).Return(role.Access{Roles: []string{"admin"}, Permissions: []string{"courses:write"}}, nil)
+
+	jwt_ := jwtMock{}
+	jwt_.On("Create", idToken, "_user_id_", []string{"admin", "beta"}, []string{"courses:write"}, map[string]interface{}{"plan": "pro"}).Return("token", nil)
+
+	refresher_ := refresherMock{}
+	refresher_.On("Create", "token").Return("refresh", nil)
+
+	var login hook.Login
+
+	users := userRepositoryMock{}
+	users.On("FindByIdentity", mock.Anything).Return(user.User{}, user.ErrNotFound)
+	users.On("Upsert", mock.MatchedBy(func(u user.User) bool {
+		return u.ID == login.User.ID
+	})).Return(user.User{ID: "_user_id_"}, nil)
+
+	var subject string
+	hooks := hook.Chain{
+		hook.Func(func(ctx context.Context, l *hook.Login) error {
+			login = *l
+			l.Roles = append(l.Roles, "beta")
+			l.SetClaim("plan", "pro")
+			return readSubject(l, &subject)
+		}),
+	}
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roles, &users, &emails, hooks)
+
+	// When
+	_, err := s.VerifyAuthentication(ctx, authentication, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.NotEmpty(t, login.User.ID)
+	require.Equal(t, "google", login.User.Provider)
+	require.Equal(t, idToken, login.Identity)
+	require.Equal(t, "google-oauth2", subject)
+	jwt_.AssertExpectations(t)
+	users.AssertExpectations(t)
+}
+
+func TestService_VerifyAuthentication_HookErrors(t *testing.T) {
 	tt := []struct {
 		name          string
 		returnedError error
 		expectedError string
+		expectedIs    error
 	}{
 		{
-			name:          "generic error",
-			returnedError: errors.New("error"),
-			expectedError: "could not create token: error",
-		},
-		{
-			name:          "not found error",
-			returnedError: jwt.ErrNotFound,
-			expectedError: "could not create token: authentication: could not create resource",
+			name:          "denied login",
+			returnedError: &hook.DeniedError{Reason: hook.ReasonSuspended},
+			expectedError: "could not run hooks: sign in is not allowed: user_suspended",
+			expectedIs:    ErrForbidden,
 		},
 		{
-			name:          "unsupported provider error",
-			returnedError: jwt.ErrUnsupportedProvider,
-			expectedError: "could not create token: authentication: could not create resource",
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not run hooks: error",
 		},
 	}
 
@@ -176,73 +437,177 @@ func TestService_VerifyAuthentication_JWTCreateTokenErrors(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			// Given
 			ctx := context.Background()
-			idToken := &oidc.IDToken{Subject: "google-oauth2"}
+			idToken := newIdentity()
 			code := "_code_"
+			authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
 
 			authenticator := authenticatorMock{}
-			authenticator.On("VerifyAuthentication", ctx, code).Return(idToken, nil)
+			authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+			emails := emailPolicyMock{}
+			emails.On("Evaluate", idToken).Return(nil)
+
+			roles := roleResolverMock{}
+			roles.On("Resolve", idToken).Return(role.Access{}, nil)
+
+			users := userRepositoryMock{}
+			users.On("FindByIdentity", mock.Anything).Return(user.User{}, user.ErrNotFound)
+
+			hooks := hook.Chain{
+				hook.Func(func(ctx context.Context, l *hook.Login) error {
+					return tc.returnedError
+				}),
+			}
 
 			jwt_ := jwtMock{}
-			jwt_.On("Create", idToken, "google-oauth2").Return("", tc.returnedError)
 
-			s := NewService(&authenticator, &jwt_)
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresherMock{}, &clientRegistryMock{}, &roles, &users, &emails, hooks)
 
 			// When
-			_, err := s.VerifyAuthentication(ctx, code)
+			_, err := s.VerifyAuthentication(ctx, authentication, code)
 			if err == nil {
 				t.Fatal("test must fail")
 			}
 
 			// Then
 			require.EqualError(t, err, tc.expectedError)
+			if tc.expectedIs != nil {
+				require.True(t, errors.Is(err, tc.expectedIs))
+			}
+
+			users.AssertNotCalled(t, "Upsert", mock.Anything)
+			jwt_.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 		})
 	}
 }
 
-type claimsMock struct {
-	mock.Mock
-}
-
-func (c *claimsMock) Valid() error {
-	return nil
-}
-
-func TestService_GetMyInformation(t *testing.T) {
+func TestService_LinkAuthentication(t *testing.T) {
 	// Given
+	ctx := context.Background()
+	idToken := newIdentity()
+	code := "_code_"
+	authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
+	pending := PendingLink{Identity: user.Identity{Provider: "github", Subject: "github|1234"}, Email: "user@example.com"}
+
 	authenticator := authenticatorMock{}
+	authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", idToken).Return(nil)
+
+	roles := roleResolverMock{}
+	roles.On("Resolve", idToken).Return(role.Access{}, nil)
+
 	jwt_ := jwtMock{}
-	jwt_.On("Claims", "token").Return(&claimsMock{}, nil)
+	jwt_.On("Create", idToken, "_user_id_", []string(nil), []string(nil), map[string]interface{}(nil)).Return("token", nil)
+
+	refresher_ := refresherMock{}
+	refresher_.On("Create", "token").Return("refresh", nil)
 
-	s := NewService(&authenticator, &jwt_)
+	stored := user.User{ID: "_user_id_", Identities: []user.Identity{{Provider: "google", Subject: "google-oauth2"}}}
+
+	users := userRepositoryMock{}
+	users.On("FindByIdentity", user.Identity{Provider: "google", Subject: "google-oauth2"}).Return(stored, nil)
+	users.On("FindByEmail", "user@example.com").Return(stored, nil)
+	users.On("FindByIdentity", pending.Identity).Return(user.User{}, user.ErrNotFound)
+	users.On("Upsert", mock.MatchedBy(func(u user.User) bool {
+		return u.ID == "_user_id_" && u.HasIdentity(pending.Identity)
+	})).Return(stored.Link(pending.Identity), nil)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roles, &users, &emails, hook.Chain{})
 
 	// When
-	myInformation, err := s.GetMyInformation("Bearer token")
+	tokens, err := s.LinkAuthentication(ctx, authentication, code, pending)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Then
-	require.NotNil(t, myInformation)
+	require.Equal(t, "token", tokens.AccessToken)
+	users.AssertExpectations(t)
 }
 
-func TestService_GetMyInformation_InvalidTokenLengthError(t *testing.T) {
-	// Given
-	authenticator := authenticatorMock{}
-	jwt_ := jwtMock{}
-
-	s := NewService(&authenticator, &jwt_)
+func TestService_LinkAuthentication_Errors(t *testing.T) {
+	pending := PendingLink{Identity: user.Identity{Provider: "github", Subject: "github|1234"}, Email: "user@example.com"}
 
-	// When
-	_, err := s.GetMyInformation("token")
-	if err == nil {
-		t.Fatal("test must fail")
+	tt := []struct {
+		name          string
+		user          user.User
+		userError     error
+		owner         user.User
+		ownerError    error
+		linked        user.User
+		linkedError   error
+		expectedError string
+	}{
+		{
+			name:          "unknown user",
+			userError:     user.ErrNotFound,
+			expectedError: "could not find user to link: authentication: could not verify resource",
+		},
+		{
+			name:          "email of another user",
+			user:          user.User{ID: "_user_id_"},
+			owner:         user.User{ID: "_other_"},
+			expectedError: "could not link identity of another email: authentication: could not verify resource",
+		},
+		{
+			name:          "email of no user",
+			user:          user.User{ID: "_user_id_"},
+			ownerError:    user.ErrNotFound,
+			expectedError: "could not link identity of another email: authentication: could not verify resource",
+		},
+		{
+			name:          "identity of another user",
+			user:          user.User{ID: "_user_id_"},
+			owner:         user.User{ID: "_user_id_"},
+			linked:        user.User{ID: "_other_"},
+			expectedError: "could not link identity of another user: authentication: could not verify resource",
+		},
+		{
+			name:          "generic error",
+			user:          user.User{ID: "_user_id_"},
+			owner:         user.User{ID: "_user_id_"},
+			linkedError:   errors.New("error"),
+			expectedError: "could not find user to link: error",
+		},
 	}
 
-	// Then
-	require.EqualError(t, err, "invalid token length: authentication: could not parse resource")
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			idToken := newIdentity()
+			code := "_code_"
+			authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
+
+			authenticator := authenticatorMock{}
+			authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+			emails := emailPolicyMock{}
+			emails.On("Evaluate", idToken).Return(nil)
+
+			users := userRepositoryMock{}
+			users.On("FindByIdentity", user.Identity{Provider: "google", Subject: "google-oauth2"}).Return(tc.user, tc.userError)
+			users.On("FindByEmail", "user@example.com").Return(tc.owner, tc.ownerError)
+			users.On("FindByIdentity", pending.Identity).Return(tc.linked, tc.linkedError)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &users, &emails, hook.Chain{})
+
+			// When
+			_, err := s.LinkAuthentication(ctx, authentication, code, pending)
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+			users.AssertNotCalled(t, "Upsert", mock.Anything)
+		})
+	}
 }
 
-func TestService_GetMyInformation_GetClaimsError(t *testing.T) {
+func TestService_VerifyAuthentication_VerifyAuthenticationErrors(t *testing.T) {
 	tt := []struct {
 		name          string
 		returnedError error
@@ -251,31 +616,41 @@ func TestService_GetMyInformation_GetClaimsError(t *testing.T) {
 		{
 			name:          "generic error",
 			returnedError: errors.New("error"),
-			expectedError: "error",
+			expectedError: "could not verify authentication: error",
 		},
 		{
-			name:          "malformed token error",
-			returnedError: jwt.ErrMalformedToken,
-			expectedError: "could not fetch claims: authentication: could not create resource",
+			name:          "not found error",
+			returnedError: auth.ErrNotFound,
+			expectedError: "could not verify authentication: authentication: resource not found",
 		},
 		{
-			name:          "expired token error",
-			returnedError: jwt.ErrExpiredToken,
-			expectedError: "could not fetch claims: authentication: could not create resource",
+			name:          "unsupported provider error",
+			returnedError: auth.ErrUnsupportedProvider,
+			expectedError: "could not verify authentication: authentication: resource not found",
+		},
+		{
+			name:          "authentication error",
+			returnedError: auth.ErrAuthenticationFailed,
+			expectedError: "could not verify authentication: authentication: could not verify resource",
 		},
 	}
 
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
 			// Given
-			authenticator := authenticatorMock{}
+			ctx := context.Background()
+			code := "_code_"
+			authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
+
 			jwt_ := jwtMock{}
-			jwt_.On("Claims", "token").Return(&claimsMock{}, tc.returnedError)
+			refresher_ := refresherMock{}
+			authenticator := authenticatorMock{}
+			authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(&auth.Identity{}, tc.returnedError)
 
-			s := NewService(&authenticator, &jwt_)
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
 
 			// When
-			_, err := s.GetMyInformation("Bearer token")
+			_, err := s.VerifyAuthentication(ctx, authentication, code)
 			if err == nil {
 				t.Fatal("test must fail")
 			}
@@ -285,3 +660,1433 @@ func TestService_GetMyInformation_GetClaimsError(t *testing.T) {
 		})
 	}
 }
+
+func TestService_VerifyAuthentication_JWTCreateTokenErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not create token: error",
+		},
+		{
+			name:          "not found error",
+			returnedError: jwt.ErrNotFound,
+			expectedError: "could not create token: authentication: could not create resource",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			idToken := newIdentity()
+			code := "_code_"
+			authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
+
+			authenticator := authenticatorMock{}
+			authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+			emails := emailPolicyMock{}
+			emails.On("Evaluate", idToken).Return(nil)
+
+			roles := roleResolverMock{}
+			roles.On("Resolve", idToken).Return(role.Access{Roles: []string{"admin"}, Permissions: []string{"courses:write"}}, nil)
+
+			jwt_ := jwtMock{}
+			refresher_ := refresherMock{}
+			jwt_.On("Create", idToken, "_user_id_", []string{"admin"}, []string{"courses:write"}, map[string]interface{}(nil)).Return("", tc.returnedError)
+
+			users := userRepositoryMock{}
+			users.On("FindByIdentity", mock.Anything).Return(user.User{}, user.ErrNotFound)
+			users.On("Upsert", mock.Anything).Return(user.User{ID: "_user_id_"}, nil)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roles, &users, &emails, hook.Chain{})
+
+			// When
+			_, err := s.VerifyAuthentication(ctx, authentication, code)
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_VerifyAuthentication_SaveUserError(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	idToken := newIdentity()
+	code := "_code_"
+	authentication := Authentication{Provider: "google", State: "_state_"}
+
+	authenticator := authenticatorMock{}
+	authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", idToken).Return(nil)
+
+	roles := roleResolverMock{}
+	roles.On("Resolve", idToken).Return(role.Access{}, nil)
+
+	users := userRepositoryMock{}
+	users.On("FindByIdentity", mock.Anything).Return(user.User{}, user.ErrNotFound)
+	users.On("Upsert", mock.Anything).Return(user.User{}, errors.New("error"))
+
+	s := NewService("https://auth.example.com", &authenticator, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roles, &users, &emails, hook.Chain{})
+
+	// When
+	_, err := s.VerifyAuthentication(ctx, authentication, code)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "could not save user: error")
+}
+
+func TestService_VerifyAuthentication_ResolveRolesError(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	idToken := newIdentity()
+	code := "_code_"
+	authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
+
+	authenticator := authenticatorMock{}
+	authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", idToken).Return(nil)
+
+	roles := roleResolverMock{}
+	roles.On("Resolve", idToken).Return(role.Access{}, errors.New("error"))
+
+	users := userRepositoryMock{}
+	users.On("FindByIdentity", mock.Anything).Return(user.User{}, user.ErrNotFound)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roles, &users, &emails, hook.Chain{})
+
+	// When
+	_, err := s.VerifyAuthentication(ctx, authentication, code)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "could not resolve roles: error")
+	users.AssertNotCalled(t, "Upsert", mock.Anything)
+}
+
+func TestService_VerifyAuthentication_CreateRefreshTokenError(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	idToken := newIdentity()
+	code := "_code_"
+	authentication := Authentication{Provider: "google", State: "_state_", CodeVerifier: "_verifier_"}
+
+	authenticator := authenticatorMock{}
+	authenticator.On("VerifyAuthentication", ctx, authentication, code).Return(idToken, nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", idToken).Return(nil)
+
+	roles := roleResolverMock{}
+	roles.On("Resolve", idToken).Return(role.Access{Roles: []string{"admin"}, Permissions: []string{"courses:write"}}, nil)
+
+	jwt_ := jwtMock{}
+	jwt_.On("Create", idToken, "_user_id_", []string{"admin"}, []string{"courses:write"}, map[string]interface{}(nil)).Return("token", nil)
+
+	refresher_ := refresherMock{}
+	refresher_.On("Create", "token").Return("", errors.New("error"))
+
+	users := userRepositoryMock{}
+	users.On("FindByIdentity", mock.Anything).Return(user.User{}, user.ErrNotFound)
+	users.On("Upsert", mock.Anything).Return(user.User{ID: "_user_id_"}, nil)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roles, &users, &emails, hook.Chain{})
+
+	// When
+	_, err := s.VerifyAuthentication(ctx, authentication, code)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "could not create refresh token: error")
+}
+
+func newRefreshedUser() user.User {
+	return user.User{
+		ID:            "_user_id_",
+		Email:         "user@example.com",
+		EmailVerified: true,
+		Identities:    []user.Identity{{Provider: "google", Subject: "google-oauth2|1234"}},
+	}
+}
+
+// readSubject reads the sub claim of the identity logging in, as hooks do.
+func readSubject(l *hook.Login, subject *string) error {
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+
+	if err := l.Identity.Claims(&claims); err != nil {
+		return err
+	}
+
+	*subject = claims.Subject
+	return nil
+}
+
+func TestService_Refresh(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	claims := jwt.CClaims{
+		Roles:            []string{"admin"},
+		Permissions:      []string{"courses:write"},
+		Extra:            map[string]interface{}{"plan": "free"},
+		RegisteredClaims: gojwt.RegisteredClaims{Subject: "_user_id_"},
+	}
+
+	authenticator := authenticatorMock{}
+	jwt_ := jwtMock{}
+	jwt_.On("RenewableClaims", "token").Return(claims, nil)
+	jwt_.On("Renew", jwt.CClaims{
+		Roles:            []string{"admin", "beta"},
+		Permissions:      []string{"courses:write"},
+		Extra:            map[string]interface{}{"plan": "pro"},
+		RegisteredClaims: gojwt.RegisteredClaims{Subject: "_user_id_"},
+	}).Return("new token", nil)
+
+	refresher_ := refresherMock{}
+	refresher_.On("Lookup", "refresh").Return(refresh.Token{AccessToken: "token"}, nil)
+	refresher_.On("Rotate", "refresh", "new token").Return("new refresh", nil)
+
+	users := userRepositoryMock{}
+	users.On("Get", "_user_id_").Return(newRefreshedUser(), nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", mock.MatchedBy(func(v email.UnmarshalClaims) bool {
+		var c struct {
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"email_verified"`
+		}
+
+		return v.Claims(&c) == nil && c.Email == "user@example.com" && c.EmailVerified
+	})).Return(nil)
+
+	var (
+		login   hook.Login
+		subject string
+	)
+
+	hooks := hook.Chain{
+		hook.Func(func(ctx context.Context, l *hook.Login) error {
+			login = *l
+			l.Roles = append(l.Roles, "beta")
+			l.SetClaim("plan", "pro")
+			return readSubject(l, &subject)
+		}),
+	}
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &users, &emails, hooks)
+
+	// When
+	tokens, err := s.Refresh(ctx, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, Tokens{AccessToken: "new token", RefreshToken: "new refresh", TokenType: "Bearer"}, tokens)
+	require.Equal(t, newRefreshedUser(), login.User)
+	require.Equal(t, []string{"admin"}, login.Roles)
+	require.Equal(t, "google-oauth2|1234", subject)
+	jwt_.AssertExpectations(t)
+	emails.AssertExpectations(t)
+}
+
+func TestService_Refresh_LookupErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not find refresh token: error",
+		},
+		{
+			name:          "not found error",
+			returnedError: refresh.ErrNotFound,
+			expectedError: "could not find refresh token: authentication: could not verify resource",
+		},
+		{
+			name:          "expired token error",
+			returnedError: refresh.ErrExpiredToken,
+			expectedError: "could not find refresh token: authentication: could not verify resource",
+		},
+		{
+			name:          "reused token error",
+			returnedError: refresh.ErrReusedToken,
+			expectedError: "could not find refresh token: authentication: could not verify resource",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			refresher_ := refresherMock{}
+			refresher_.On("Lookup", "refresh").Return(refresh.Token{}, tc.returnedError)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			_, err := s.Refresh(context.Background(), "refresh")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_Refresh_RenewableClaimsErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not renew token: error",
+		},
+		{
+			name:          "malformed token error",
+			returnedError: fmt.Errorf("could not handle jwt: %w: unexpected key id", jwt.ErrMalformedToken),
+			expectedError: "could not renew token: authentication: could not verify resource",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("RenewableClaims", "token").Return(jwt.CClaims{}, tc.returnedError)
+
+			refresher_ := refresherMock{}
+			refresher_.On("Lookup", "refresh").Return(refresh.Token{AccessToken: "token"}, nil)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			_, err := s.Refresh(context.Background(), "refresh")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_Refresh_GetUserErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not get user: error",
+		},
+		{
+			name:          "not found error",
+			returnedError: user.ErrNotFound,
+			expectedError: "could not get user: authentication: could not verify resource",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			jwt_ := jwtMock{}
+			jwt_.On("RenewableClaims", "token").Return(jwt.CClaims{RegisteredClaims: gojwt.RegisteredClaims{Subject: "_user_id_"}}, nil)
+
+			refresher_ := refresherMock{}
+			refresher_.On("Lookup", "refresh").Return(refresh.Token{AccessToken: "token"}, nil)
+
+			users := userRepositoryMock{}
+			users.On("Get", "_user_id_").Return(user.User{}, tc.returnedError)
+
+			s := NewService("https://auth.example.com", &authenticatorMock{}, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &users, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			_, err := s.Refresh(context.Background(), "refresh")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_Refresh_ForbiddenErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		emailError    error
+		hookError     error
+		expectedError string
+	}{
+		{
+			name:          "denied email",
+			emailError:    &email.DeniedError{Reason: email.ReasonDomainNotAllowed},
+			expectedError: "could not verify email: sign in is not allowed: email_domain_not_allowed",
+		},
+		{
+			name:          "denied login",
+			hookError:     &hook.DeniedError{Reason: hook.ReasonSuspended},
+			expectedError: "could not run hooks: sign in is not allowed: user_suspended",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			jwt_ := jwtMock{}
+			jwt_.On("RenewableClaims", "token").Return(jwt.CClaims{RegisteredClaims: gojwt.RegisteredClaims{Subject: "_user_id_"}}, nil)
+
+			refresher_ := refresherMock{}
+			refresher_.On("Lookup", "refresh").Return(refresh.Token{AccessToken: "token"}, nil)
+
+			users := userRepositoryMock{}
+			users.On("Get", "_user_id_").Return(newRefreshedUser(), nil)
+
+			emails := emailPolicyMock{}
+			emails.On("Evaluate", mock.Anything).Return(tc.emailError)
+
+			hooks := hook.Chain{
+				hook.Func(func(ctx context.Context, l *hook.Login) error {
+					return tc.hookError
+				}),
+			}
+
+			s := NewService("https://auth.example.com", &authenticatorMock{}, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &users, &emails, hooks)
+
+			// When
+			_, err := s.Refresh(context.Background(), "refresh")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+			require.True(t, errors.Is(err, ErrForbidden))
+			jwt_.AssertNotCalled(t, "Renew", mock.Anything)
+			refresher_.AssertNotCalled(t, "Rotate", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestService_Refresh_RotateError(t *testing.T) {
+	// Given
+	jwt_ := jwtMock{}
+	jwt_.On("RenewableClaims", "token").Return(jwt.CClaims{RegisteredClaims: gojwt.RegisteredClaims{Subject: "_user_id_"}}, nil)
+	jwt_.On("Renew", jwt.CClaims{RegisteredClaims: gojwt.RegisteredClaims{Subject: "_user_id_"}}).Return("new token", nil)
+
+	refresher_ := refresherMock{}
+	refresher_.On("Lookup", "refresh").Return(refresh.Token{AccessToken: "token"}, nil)
+	refresher_.On("Rotate", "refresh", "new token").Return("", refresh.ErrReusedToken)
+
+	users := userRepositoryMock{}
+	users.On("Get", "_user_id_").Return(newRefreshedUser(), nil)
+
+	emails := emailPolicyMock{}
+	emails.On("Evaluate", mock.Anything).Return(nil)
+
+	s := NewService("https://auth.example.com", &authenticatorMock{}, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &users, &emails, hook.Chain{})
+
+	// When
+	_, err := s.Refresh(context.Background(), "refresh")
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "could not rotate refresh token: authentication: could not verify resource")
+}
+
+func (r *refresherMock) Revoke(refreshToken string) error {
+	args := r.Called(refreshToken)
+	return args.Error(0)
+}
+
+func TestService_Logout(t *testing.T) {
+	// Given
+	authenticator := authenticatorMock{}
+	jwt_ := jwtMock{}
+	jwt_.On("Revoke", "token").Return(nil)
+
+	refresher_ := refresherMock{}
+	refresher_.On("Revoke", "refresh").Return(nil)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	err := s.Logout("token", "refresh")
+
+	// Then
+	require.NoError(t, err)
+	jwt_.AssertExpectations(t)
+	refresher_.AssertExpectations(t)
+}
+
+func TestService_Logout_IgnoresUnknownTokens(t *testing.T) {
+	// Given
+	authenticator := authenticatorMock{}
+	jwt_ := jwtMock{}
+	jwt_.On("Revoke", "token").Return(jwt.ErrMalformedToken)
+
+	refresher_ := refresherMock{}
+	refresher_.On("Revoke", "refresh").Return(refresh.ErrNotFound)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	err := s.Logout("token", "refresh")
+
+	// Then
+	require.NoError(t, err)
+}
+
+func TestService_Logout_Errors(t *testing.T) {
+	tt := []struct {
+		name          string
+		jwtError      error
+		refreshError  error
+		expectedError string
+	}{
+		{
+			name:          "revoke token error",
+			jwtError:      errors.New("error"),
+			expectedError: "could not revoke token: error",
+		},
+		{
+			name:          "revoke refresh token error",
+			refreshError:  errors.New("error"),
+			expectedError: "could not revoke refresh token: error",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("Revoke", "token").Return(tc.jwtError)
+
+			refresher_ := refresherMock{}
+			refresher_.On("Revoke", "refresh").Return(tc.refreshError)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			err := s.Logout("token", "refresh")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_EndSession(t *testing.T) {
+	// Given
+	authenticator := authenticatorMock{}
+	authenticator.On("EndSessionURL", "google", "_id_token_", "https://app.example.com").Return("https://issuer.example.com/logout", nil)
+
+	s := NewService("https://auth.example.com", &authenticator, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	endSessionURL, err := s.EndSession("google", "_id_token_", "https://app.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, "https://issuer.example.com/logout", endSessionURL)
+}
+
+func TestService_EndSession_Errors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not end session: error",
+		},
+		{
+			name:          "unsupported provider error",
+			returnedError: auth.ErrUnsupportedProvider,
+			expectedError: "could not end session: authentication: resource not found",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			authenticator.On("EndSessionURL", "google", "", "").Return("", tc.returnedError)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			_, err := s.EndSession("google", "", "")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+type clientRegistryMock struct {
+	mock.Mock
+}
+
+func (c *clientRegistryMock) Authenticate(id string, secret string) (client.Client, error) {
+	args := c.Called(id, secret)
+	return args.Get(0).(client.Client), args.Error(1)
+}
+
+type roleResolverMock struct {
+	mock.Mock
+}
+
+func (r *roleResolverMock) Resolve(v role.UnmarshalClaims) (role.Access, error) {
+	args := r.Called(v)
+	return args.Get(0).(role.Access), args.Error(1)
+}
+
+type emailPolicyMock struct {
+	mock.Mock
+}
+
+func (e *emailPolicyMock) Evaluate(v email.UnmarshalClaims) error {
+	args := e.Called(v)
+	return args.Error(0)
+}
+
+type userRepositoryMock struct {
+	mock.Mock
+}
+
+func (u *userRepositoryMock) Get(id string) (user.User, error) {
+	args := u.Called(id)
+	return args.Get(0).(user.User), args.Error(1)
+}
+
+func (u *userRepositoryMock) FindByIdentity(identity user.Identity) (user.User, error) {
+	args := u.Called(identity)
+	return args.Get(0).(user.User), args.Error(1)
+}
+
+func (u *userRepositoryMock) FindByEmail(email string) (user.User, error) {
+	args := u.Called(email)
+	return args.Get(0).(user.User), args.Error(1)
+}
+
+func (u *userRepositoryMock) Upsert(user_ user.User) (user.User, error) {
+	args := u.Called(user_)
+	return args.Get(0).(user.User), args.Error(1)
+}
+
+func TestService_CreateClientToken(t *testing.T) {
+	tt := []struct {
+		name           string
+		scope          string
+		expectedScopes []string
+	}{
+		{
+			name:           "requested scopes",
+			scope:          "courses:read",
+			expectedScopes: []string{"courses:read"},
+		},
+		{
+			name:           "every allowed scope",
+			expectedScopes: []string{"courses:read", "courses:write"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("CreateForClient", "_client_", tc.expectedScopes).Return("token", nil)
+
+			clients := clientRegistryMock{}
+			clients.On("Authenticate", "_client_", "_secret_").Return(client.Client{ID: "_client_", Scopes: []string{"courses:read", "courses:write"}}, nil)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresherMock{}, &clients, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			tokens, err := s.CreateClientToken("_client_", "_secret_", tc.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.Equal(t, Tokens{AccessToken: "token", TokenType: "Bearer", Scope: strings.Join(tc.expectedScopes, " ")}, tokens)
+		})
+	}
+}
+
+func TestService_CreateClientToken_Errors(t *testing.T) {
+	tt := []struct {
+		name          string
+		scope         string
+		clientError   error
+		jwtError      error
+		expectedError string
+	}{
+		{
+			name:          "invalid client credentials",
+			clientError:   client.ErrInvalidCredentials,
+			expectedError: "could not authenticate client: authentication: could not verify resource",
+		},
+		{
+			name:          "scope not allowed",
+			scope:         "courses:read users:write",
+			expectedError: "could not grant scope: authentication: scope is not allowed",
+		},
+		{
+			name:          "create token error",
+			jwtError:      errors.New("error"),
+			expectedError: "could not create token: error",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("CreateForClient", "_client_", []string{"courses:read"}).Return("", tc.jwtError)
+
+			clients := clientRegistryMock{}
+			clients.On("Authenticate", "_client_", "_secret_").Return(client.Client{ID: "_client_", Scopes: []string{"courses:read"}}, tc.clientError)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresherMock{}, &clients, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			_, err := s.CreateClientToken("_client_", "_secret_", tc.scope)
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_Introspect(t *testing.T) {
+	tt := []struct {
+		name     string
+		claims   jwt.CClaims
+		expected string
+	}{
+		{
+			name: "user token",
+			claims: jwt.CClaims{
+				Metadata:    &jwt.MetaData{Name: "_name_"},
+				Upstream:    &jwt.Upstream{Subject: "google-oauth2|1234"},
+				Permissions: []string{"read:orders", "write:orders"},
+				RegisteredClaims: gojwt.RegisteredClaims{
+					ID:        "_jti_",
+					Subject:   "_user_id_",
+					Audience:  gojwt.ClaimStrings{"https://api.example.com"},
+					ExpiresAt: gojwt.NewNumericDate(time.Unix(1700000000, 0)),
+				},
+			},
+			expected: `{"active":true,"scope":"read:orders write:orders","token_type":"Bearer","sub":"_user_id_","aud":["https://api.example.com"],"exp":1700000000,"jti":"_jti_"}`,
+		},
+		{
+			name: "client token",
+			claims: jwt.CClaims{
+				Scope: "read:users",
+				RegisteredClaims: gojwt.RegisteredClaims{
+					ID:        "_jti_",
+					Subject:   "_other_client_",
+					Audience:  gojwt.ClaimStrings{"https://api.example.com"},
+					ExpiresAt: gojwt.NewNumericDate(time.Unix(1700000000, 0)),
+				},
+			},
+			expected: `{"active":true,"scope":"read:users","client_id":"_other_client_","token_type":"Bearer","sub":"_other_client_","aud":["https://api.example.com"],"exp":1700000000,"jti":"_jti_"}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("Claims", "token").Return(tc.claims, nil)
+
+			clients := clientRegistryMock{}
+			clients.On("Authenticate", "_client_", "_secret_").Return(client.Client{ID: "_client_"}, nil)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresherMock{}, &clients, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			b, err := s.Introspect("_client_", "_secret_", "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.JSONEq(t, tc.expected, string(b))
+		})
+	}
+}
+
+func TestService_Introspect_InactiveToken(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+	}{
+		{
+			name:          "malformed token",
+			returnedError: jwt.ErrMalformedToken,
+		},
+		{
+			name:          "expired token",
+			returnedError: jwt.ErrExpiredToken,
+		},
+		{
+			name:          "revoked token",
+			returnedError: jwt.ErrRevokedToken,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("Claims", "token").Return(jwt.CClaims{}, tc.returnedError)
+
+			clients := clientRegistryMock{}
+			clients.On("Authenticate", "_client_", "_secret_").Return(client.Client{ID: "_client_"}, nil)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresherMock{}, &clients, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			b, err := s.Introspect("_client_", "_secret_", "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.JSONEq(t, `{"active":false}`, string(b))
+		})
+	}
+}
+
+func TestService_Introspect_Errors(t *testing.T) {
+	tt := []struct {
+		name          string
+		clientError   error
+		claimsError   error
+		expectedError string
+	}{
+		{
+			name:          "invalid client credentials",
+			clientError:   client.ErrInvalidCredentials,
+			expectedError: "could not authenticate client: authentication: could not verify resource",
+		},
+		{
+			name:          "claims error",
+			claimsError:   errors.New("error"),
+			expectedError: "could not fetch claims: error",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("Claims", "token").Return(jwt.CClaims{}, tc.claimsError)
+
+			clients := clientRegistryMock{}
+			clients.On("Authenticate", "_client_", "_secret_").Return(client.Client{}, tc.clientError)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresherMock{}, &clients, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			_, err := s.Introspect("_client_", "_secret_", "token")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_RevokeToken(t *testing.T) {
+	clientToken := jwt.CClaims{RegisteredClaims: gojwt.RegisteredClaims{Subject: "_client_"}}
+	userToken := jwt.CClaims{Upstream: &jwt.Upstream{Subject: "google-oauth2|1234"}, RegisteredClaims: gojwt.RegisteredClaims{Subject: "_user_id_"}}
+
+	tt := []struct {
+		name          string
+		client        client.Client
+		tokenTypeHint string
+		claims        jwt.CClaims
+		claimsError   error
+		refreshError  error
+		jwtCalled     bool
+		refreshCalled bool
+	}{
+		{
+			name:          "access token",
+			client:        client.Client{ID: "_client_"},
+			tokenTypeHint: "access_token",
+			claims:        clientToken,
+			jwtCalled:     true,
+		},
+		{
+			name:      "access token without hint",
+			client:    client.Client{ID: "_client_"},
+			claims:    clientToken,
+			jwtCalled: true,
+		},
+		{
+			name:          "access token with refresh hint of a client revoking only its tokens",
+			client:        client.Client{ID: "_client_"},
+			tokenTypeHint: "refresh_token",
+			claims:        clientToken,
+			jwtCalled:     true,
+		},
+		{
+			name:      "user token",
+			client:    client.Client{ID: "_client_", RevokesUserTokens: true},
+			claims:    userToken,
+			jwtCalled: true,
+		},
+		{
+			name:          "refresh token",
+			client:        client.Client{ID: "_client_", RevokesUserTokens: true},
+			tokenTypeHint: "refresh_token",
+			refreshCalled: true,
+		},
+		{
+			name:          "refresh token with wrong hint",
+			client:        client.Client{ID: "_client_", RevokesUserTokens: true},
+			tokenTypeHint: "access_token",
+			claimsError:   jwt.ErrMalformedToken,
+			refreshCalled: true,
+		},
+		{
+			name:          "access token with wrong hint",
+			client:        client.Client{ID: "_client_", RevokesUserTokens: true},
+			tokenTypeHint: "refresh_token",
+			claims:        userToken,
+			refreshError:  refresh.ErrNotFound,
+			jwtCalled:     true,
+			refreshCalled: true,
+		},
+		{
+			name:          "unknown token",
+			client:        client.Client{ID: "_client_", RevokesUserTokens: true},
+			claimsError:   jwt.ErrMalformedToken,
+			refreshError:  refresh.ErrNotFound,
+			refreshCalled: true,
+		},
+		{
+			name:        "unknown token of a client revoking only its tokens",
+			client:      client.Client{ID: "_client_"},
+			claimsError: jwt.ErrMalformedToken,
+		},
+		{
+			name:          "refresh token of a client revoking only its tokens",
+			client:        client.Client{ID: "_client_"},
+			tokenTypeHint: "refresh_token",
+			claimsError:   jwt.ErrMalformedToken,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("RenewableClaims", "token").Return(tc.claims, tc.claimsError)
+			jwt_.On("Revoke", "token").Return(nil)
+
+			refresher_ := refresherMock{}
+			refresher_.On("Revoke", "token").Return(tc.refreshError)
+
+			clients := clientRegistryMock{}
+			clients.On("Authenticate", "_client_", "_secret_").Return(tc.client, nil)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clients, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			err := s.RevokeToken("_client_", "_secret_", "token", tc.tokenTypeHint)
+
+			// Then
+			require.NoError(t, err)
+
+			if tc.jwtCalled {
+				jwt_.AssertCalled(t, "Revoke", "token")
+			} else {
+				jwt_.AssertNotCalled(t, "Revoke", "token")
+			}
+
+			if tc.refreshCalled {
+				refresher_.AssertCalled(t, "Revoke", "token")
+			} else {
+				refresher_.AssertNotCalled(t, "Revoke", "token")
+			}
+		})
+	}
+}
+
+func TestService_RevokeToken_NotOwnerErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		claims        jwt.CClaims
+		claimsError   error
+		expectedError string
+	}{
+		{
+			name:          "token of another client",
+			claims:        jwt.CClaims{RegisteredClaims: gojwt.RegisteredClaims{Subject: "_another_client_"}},
+			expectedError: "could not revoke token: authentication: token belongs to another party",
+		},
+		{
+			name:          "token of a user",
+			claims:        jwt.CClaims{Upstream: &jwt.Upstream{Subject: "_client_"}, RegisteredClaims: gojwt.RegisteredClaims{Subject: "_client_"}},
+			expectedError: "could not revoke token: authentication: token belongs to another party",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			jwt_ := jwtMock{}
+			jwt_.On("RenewableClaims", "token").Return(tc.claims, tc.claimsError)
+
+			refresher_ := refresherMock{}
+
+			clients := clientRegistryMock{}
+			clients.On("Authenticate", "_client_", "_secret_").Return(client.Client{ID: "_client_"}, nil)
+
+			s := NewService("https://auth.example.com", &authenticatorMock{}, &jwt_, &refresher_, &clients, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			err := s.RevokeToken("_client_", "_secret_", "token", "")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+			require.True(t, errors.Is(err, ErrNotOwner))
+			jwt_.AssertNotCalled(t, "Revoke", mock.Anything)
+			refresher_.AssertNotCalled(t, "Revoke", mock.Anything)
+		})
+	}
+}
+
+func TestService_RevokeToken_Errors(t *testing.T) {
+	tt := []struct {
+		name          string
+		clientError   error
+		claimsError   error
+		jwtError      error
+		refreshError  error
+		expectedError string
+	}{
+		{
+			name:          "invalid client credentials",
+			clientError:   client.ErrInvalidCredentials,
+			expectedError: "could not authenticate client: authentication: could not verify resource",
+		},
+		{
+			name:          "fetch claims error",
+			claimsError:   errors.New("error"),
+			expectedError: "could not fetch claims: error",
+		},
+		{
+			name:          "revoke token error",
+			jwtError:      errors.New("error"),
+			expectedError: "could not revoke token: error",
+		},
+		{
+			name:          "revoke refresh token error",
+			claimsError:   jwt.ErrMalformedToken,
+			refreshError:  errors.New("error"),
+			expectedError: "could not revoke refresh token: error",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			authenticator := authenticatorMock{}
+			jwt_ := jwtMock{}
+			jwt_.On("RenewableClaims", "token").Return(jwt.CClaims{RegisteredClaims: gojwt.RegisteredClaims{Subject: "_client_"}}, tc.claimsError)
+			jwt_.On("Revoke", "token").Return(tc.jwtError)
+
+			refresher_ := refresherMock{}
+			refresher_.On("Revoke", "token").Return(tc.refreshError)
+
+			clients := clientRegistryMock{}
+			clients.On("Authenticate", "_client_", "_secret_").Return(client.Client{ID: "_client_", RevokesUserTokens: true}, tc.clientError)
+
+			s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clients, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			err := s.RevokeToken("_client_", "_secret_", "token", "")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_GetUserInfo(t *testing.T) {
+	// Given
+	s := NewService("https://auth.example.com", &authenticatorMock{}, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	b, err := s.GetUserInfo(jwt.CClaims{
+		Metadata:         &jwt.MetaData{Name: "_name_", Email: "_email_", AvatarURL: "_picture_"},
+		RegisteredClaims: gojwt.RegisteredClaims{Subject: "google-oauth2|1234"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.JSONEq(t, `{"sub":"google-oauth2|1234","name":"_name_","email":"_email_","picture":"_picture_"}`, string(b))
+}
+
+func TestService_GetConfiguration(t *testing.T) {
+	// Given
+	s := NewService("https://auth.example.com/", &authenticatorMock{}, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	b, err := s.GetConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.JSONEq(t, `{
+		"issuer": "https://auth.example.com",
+		"token_endpoint": "https://auth.example.com/oauth/token",
+		"response_types_supported": [],
+		"userinfo_endpoint": "https://auth.example.com/userinfo",
+		"jwks_uri": "https://auth.example.com/.well-known/jwks.json",
+		"introspection_endpoint": "https://auth.example.com/oauth/introspect",
+		"revocation_endpoint": "https://auth.example.com/oauth/revoke",
+		"grant_types_supported": ["client_credentials"],
+		"claims_supported": ["sub", "iss", "aud", "exp", "iat", "nbf", "name", "email", "picture"],
+		"token_endpoint_auth_methods_supported": ["client_secret_basic", "client_secret_post"],
+		"introspection_endpoint_auth_methods_supported": ["client_secret_basic", "client_secret_post"],
+		"revocation_endpoint_auth_methods_supported": ["client_secret_basic", "client_secret_post"]
+	}`, string(b))
+}
+
+func TestNewProfile(t *testing.T) {
+	tt := []struct {
+		name         string
+		claims       jwt.CClaims
+		expectedJSON string
+	}{
+		{
+			name: "user token",
+			claims: jwt.CClaims{
+				Metadata:    &jwt.MetaData{Name: "_name_", Email: "_email_", AvatarURL: "_picture_"},
+				Roles:       []string{"admin"},
+				Permissions: []string{"courses:write"},
+				RegisteredClaims: gojwt.RegisteredClaims{
+					Subject:   "_sub_",
+					IssuedAt:  gojwt.NewNumericDate(time.Unix(1000, 0)),
+					ExpiresAt: gojwt.NewNumericDate(time.Unix(4600, 0)),
+				},
+			},
+			expectedJSON: `{"sub":"_sub_","metadata":{"name":"_name_","email":"_email_","avatar_url":"_picture_"},"roles":["admin"],"permissions":["courses:write"],"iat":1000,"exp":4600}`,
+		},
+		{
+			name: "client token",
+			claims: jwt.CClaims{
+				Scope:            "courses:read",
+				RegisteredClaims: gojwt.RegisteredClaims{Subject: "_client_"},
+			},
+			expectedJSON: `{"sub":"_client_","roles":[],"permissions":[],"scope":"courses:read","iat":0,"exp":0}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// When
+			b, err := json.Marshal(NewProfile(tc.claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.JSONEq(t, tc.expectedJSON, string(b))
+		})
+	}
+}
+
+func TestService_GetProfile(t *testing.T) {
+	// Given
+	firstLoginAt := time.Unix(1000, 0).UTC()
+	lastLoginAt := time.Unix(2000, 0).UTC()
+
+	users := userRepositoryMock{}
+	users.On("Get", "_sub_").Return(user.User{
+		ID:           "_sub_",
+		Name:         "_stored_name_",
+		Email:        "_email_",
+		Provider:     "google",
+		FirstLoginAt: firstLoginAt,
+		LastLoginAt:  lastLoginAt,
+	}, nil)
+
+	s := NewService("https://auth.example.com", &authenticatorMock{}, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &users, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	profile, err := s.GetProfile(jwt.CClaims{
+		Metadata:         &jwt.MetaData{Name: "_name_"},
+		Roles:            []string{"admin"},
+		RegisteredClaims: gojwt.RegisteredClaims{Subject: "_sub_"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, Profile{
+		Subject:      "_sub_",
+		Metadata:     &jwt.MetaData{Name: "_stored_name_", Email: "_email_"},
+		Provider:     "google",
+		FirstLoginAt: &firstLoginAt,
+		LastLoginAt:  &lastLoginAt,
+		Roles:        []string{"admin"},
+		Permissions:  []string{},
+	}, profile)
+}
+
+func TestService_GetProfile_UserNotFound(t *testing.T) {
+	// Given
+	users := userRepositoryMock{}
+	users.On("Get", "_client_").Return(user.User{}, user.ErrNotFound)
+
+	s := NewService("https://auth.example.com", &authenticatorMock{}, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &users, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	profile, err := s.GetProfile(jwt.CClaims{Scope: "courses:read", RegisteredClaims: gojwt.RegisteredClaims{Subject: "_client_"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, Profile{Subject: "_client_", Scope: "courses:read", Roles: []string{}, Permissions: []string{}}, profile)
+}
+
+func TestService_GetProfile_GetUserError(t *testing.T) {
+	// Given
+	users := userRepositoryMock{}
+	users.On("Get", "_sub_").Return(user.User{}, errors.New("error"))
+
+	s := NewService("https://auth.example.com", &authenticatorMock{}, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &users, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	_, err := s.GetProfile(jwt.CClaims{RegisteredClaims: gojwt.RegisteredClaims{Subject: "_sub_"}})
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "could not get user: error")
+}
+
+func TestService_GetUser(t *testing.T) {
+	// Given
+	users := userRepositoryMock{}
+	users.On("Get", "_sub_").Return(user.User{ID: "_sub_", Name: "_name_"}, nil)
+
+	s := NewService("https://auth.example.com", &authenticatorMock{}, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &users, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	user_, err := s.GetUser("_sub_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, user.User{ID: "_sub_", Name: "_name_"}, user_)
+}
+
+func TestService_GetUser_Errors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "not found error",
+			returnedError: user.ErrNotFound,
+			expectedError: "could not get user: authentication: resource not found",
+		},
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not get user: error",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			users := userRepositoryMock{}
+			users.On("Get", "_sub_").Return(user.User{}, tc.returnedError)
+
+			s := NewService("https://auth.example.com", &authenticatorMock{}, &jwtMock{}, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &users, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			_, err := s.GetUser("_sub_")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestService_ValidateToken(t *testing.T) {
+	// Given
+	jwt_ := jwtMock{}
+	jwt_.On("Claims", "token").Return(jwt.CClaims{
+		Metadata:         &jwt.MetaData{Name: "_name_"},
+		Roles:            []string{"admin"},
+		RegisteredClaims: gojwt.RegisteredClaims{Subject: "_sub_"},
+	}, nil)
+
+	s := NewService("https://auth.example.com", &authenticatorMock{}, &jwt_, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	claims, err := s.ValidateToken("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, "_sub_", claims.Subject)
+	require.Equal(t, "_name_", claims.Metadata.Name)
+	require.Equal(t, []string{"admin"}, claims.Roles)
+}
+
+func TestService_ValidateToken_GetClaimsError(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "could not fetch claims: error",
+		},
+		{
+			name:          "malformed token error",
+			returnedError: jwt.ErrMalformedToken,
+			expectedError: "could not fetch claims: authentication: could not verify resource",
+		},
+		{
+			name:          "expired token error",
+			returnedError: jwt.ErrExpiredToken,
+			expectedError: "could not fetch claims: authentication: could not verify resource",
+		},
+		{
+			name:          "revoked token error",
+			returnedError: jwt.ErrRevokedToken,
+			expectedError: "could not fetch claims: authentication: resource has been revoked",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			jwt_ := jwtMock{}
+			jwt_.On("Claims", "token").Return(jwt.CClaims{}, tc.returnedError)
+
+			s := NewService("https://auth.example.com", &authenticatorMock{}, &jwt_, &refresherMock{}, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+			// When
+			_, err := s.ValidateToken("token")
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+func TestService_GetKeySet(t *testing.T) {
+	// Given
+	authenticator := authenticatorMock{}
+	refresher_ := refresherMock{}
+	jwt_ := jwtMock{}
+	jwt_.On("KeySet").Return(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}})
+
+	s := NewService("https://auth.example.com", &authenticator, &jwt_, &refresher_, &clientRegistryMock{}, &roleResolverMock{}, &userRepositoryMock{}, &emailPolicyMock{}, hook.Chain{})
+
+	// When
+	keySet, err := s.GetKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.JSONEq(t, `{"keys":[]}`, string(keySet))
+}
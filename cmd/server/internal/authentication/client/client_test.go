@@ -0,0 +1,166 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newClient(t *testing.T, id string, secret string) Client {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Client{ID: id, SecretHash: string(hash)}
+}
+
+func TestRegistry_Authenticate(t *testing.T) {
+	// Given
+	r, err := NewRegistry(newClient(t, "_client_", "_secret_"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	c, err := r.Authenticate("_client_", "_secret_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, "_client_", c.ID)
+}
+
+func TestRegistry_Authenticate_InvalidCredentialsError(t *testing.T) {
+	tt := []struct {
+		name   string
+		id     string
+		secret string
+	}{
+		{
+			name:   "unknown client",
+			id:     "_another_client_",
+			secret: "_secret_",
+		},
+		{
+			name:   "wrong secret",
+			id:     "_client_",
+			secret: "_another_secret_",
+		},
+		{
+			name: "empty credentials",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			r, err := NewRegistry(newClient(t, "_client_", "_secret_"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			_, err = r.Authenticate(tc.id, tc.secret)
+
+			// Then
+			require.True(t, errors.Is(err, ErrInvalidCredentials))
+		})
+	}
+}
+
+func TestNewRegistry_InvalidConfigError(t *testing.T) {
+	tt := []struct {
+		name    string
+		clients []Client
+	}{
+		{
+			name:    "missing id",
+			clients: []Client{{SecretHash: "$2a$04$abcdefghijklmnopqrstuu"}},
+		},
+		{
+			name:    "invalid hash",
+			clients: []Client{{ID: "_client_", SecretHash: "_secret_"}},
+		},
+		{
+			name:    "duplicated client",
+			clients: []Client{newClient(t, "_client_", "_secret_"), newClient(t, "_client_", "_secret_")},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// When
+			_, err := NewRegistry(tc.clients...)
+
+			// Then
+			require.True(t, errors.Is(err, ErrInvalidConfig))
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	// Given
+	c := newClient(t, "_client_", "_secret_")
+	path := filepath.Join(t.TempDir(), "clients.json")
+
+	b := []byte(`[{"id":"` + c.ID + `","secret_hash":"` + c.SecretHash + `","scopes":["courses:read"],"revokes_user_tokens":true}]`)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	r, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	c, err = r.Authenticate("_client_", "_secret_")
+	require.NoError(t, err)
+	require.Equal(t, []string{"courses:read"}, c.Scopes)
+	require.True(t, c.RevokesUserTokens)
+}
+
+func TestClient_AllowsScopes(t *testing.T) {
+	tt := []struct {
+		name    string
+		scopes  []string
+		allowed bool
+	}{
+		{
+			name:    "allowed scopes",
+			scopes:  []string{"courses:read", "courses:write"},
+			allowed: true,
+		},
+		{
+			name:    "no scopes",
+			allowed: true,
+		},
+		{
+			name:    "not allowed scope",
+			scopes:  []string{"courses:read", "users:write"},
+			allowed: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			c := Client{ID: "_client_", Scopes: []string{"courses:read", "courses:write"}}
+
+			// When
+			allowed := c.AllowsScopes(tc.scopes)
+
+			// Then
+			require.Equal(t, tc.allowed, allowed)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrDenied = errors.New("email: address is not allowed")
+
+// Reasons why an address is denied, meant to be shown to clients.
+const (
+	ReasonMissing          = "email_missing"
+	ReasonNotVerified      = "email_not_verified"
+	ReasonAddressDenied    = "email_address_denied"
+	ReasonDomainDenied     = "email_domain_denied"
+	ReasonDomainNotAllowed = "email_domain_not_allowed"
+)
+
+// DeniedError is returned when the email of a user is not allowed to sign in.
+// Reason is one of the Reason constants.
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return "email: address is not allowed: " + e.Reason
+}
+
+func (e *DeniedError) Is(target error) bool {
+	return target == ErrDenied
+}
+
+type UnmarshalClaims interface {
+	Claims(v interface{}) error
+}
+
+// Rules decide which addresses can sign in. Addresses are exceptions to the
+// domain rules: denied addresses are never let in and allowed ones always are.
+// Other addresses are let in unless their domain is denied or, when
+// AllowedDomains is set, not allowed. A domain also matches its subdomains.
+type Rules struct {
+	AllowedDomains   []string `json:"allowed_domains"`
+	DeniedDomains    []string `json:"denied_domains"`
+	AllowedAddresses []string `json:"allowed_addresses"`
+	DeniedAddresses  []string `json:"denied_addresses"`
+}
+
+func (r Rules) empty() bool {
+	return len(r.AllowedDomains) == 0 && len(r.DeniedDomains) == 0 && len(r.AllowedAddresses) == 0 && len(r.DeniedAddresses) == 0
+}
+
+// Policy checks the email of the ID token of a user against its rules. Unless
+// it has no rules at all, the email must be present and verified.
+type Policy struct {
+	rules Rules
+}
+
+func NewPolicy(rules Rules) *Policy {
+	return &Policy{rules: rules}
+}
+
+// LoadFile builds a policy whose rules are read from a JSON file.
+func LoadFile(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read email policy: %v", err)
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("could not unmarshal email policy: %v", err)
+	}
+
+	return NewPolicy(rules), nil
+}
+
+// Evaluate returns a DeniedError when the email of v is not allowed to sign
+// in.
+func (p *Policy) Evaluate(v UnmarshalClaims) error {
+	if p.rules.empty() {
+		return nil
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+
+	if err := v.Claims(&claims); err != nil {
+		return fmt.Errorf("could not fetch claims: %v", err)
+	}
+
+	address := strings.ToLower(strings.TrimSpace(claims.Email))
+	i := strings.LastIndex(address, "@")
+	if i <= 0 || i == len(address)-1 {
+		return &DeniedError{Reason: ReasonMissing}
+	}
+
+	if !claims.EmailVerified {
+		return &DeniedError{Reason: ReasonNotVerified}
+	}
+
+	domain := address[i+1:]
+
+	switch {
+	case containsAddress(p.rules.DeniedAddresses, address):
+		return &DeniedError{Reason: ReasonAddressDenied}
+	case containsAddress(p.rules.AllowedAddresses, address):
+		return nil
+	case containsDomain(p.rules.DeniedDomains, domain):
+		return &DeniedError{Reason: ReasonDomainDenied}
+	case len(p.rules.AllowedDomains) > 0 && !containsDomain(p.rules.AllowedDomains, domain):
+		return &DeniedError{Reason: ReasonDomainNotAllowed}
+	}
+
+	return nil
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if strings.EqualFold(a, address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsDomain(domains []string, domain string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "@"))
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
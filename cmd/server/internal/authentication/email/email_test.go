@@ -0,0 +1,128 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type claims []byte
+
+func (c claims) Claims(v interface{}) error {
+	return json.Unmarshal(c, v)
+}
+
+func newRules() Rules {
+	return Rules{
+		AllowedDomains:   []string{"uba.ar"},
+		DeniedDomains:    []string{"alumnos.uba.ar", "gmail.com"},
+		AllowedAddresses: []string{"guest@gmail.com"},
+		DeniedAddresses:  []string{"banned@dc.uba.ar"},
+	}
+}
+
+func TestPolicy_Evaluate(t *testing.T) {
+	tt := []struct {
+		name           string
+		claims         string
+		expectedReason string
+	}{
+		{
+			name:   "allowed domain",
+			claims: `{"email":"user@uba.ar","email_verified":true}`,
+		},
+		{
+			name:   "allowed subdomain",
+			claims: `{"email":"User@DC.UBA.AR","email_verified":true}`,
+		},
+		{
+			name:   "allowed address in a denied domain",
+			claims: `{"email":"guest@gmail.com","email_verified":true}`,
+		},
+		{
+			name:           "missing email",
+			claims:         `{"email_verified":true}`,
+			expectedReason: ReasonMissing,
+		},
+		{
+			name:           "unverified email",
+			claims:         `{"email":"user@dc.uba.ar"}`,
+			expectedReason: ReasonNotVerified,
+		},
+		{
+			name:           "denied address",
+			claims:         `{"email":"banned@dc.uba.ar","email_verified":true}`,
+			expectedReason: ReasonAddressDenied,
+		},
+		{
+			name:           "denied subdomain of an allowed domain",
+			claims:         `{"email":"user@alumnos.uba.ar","email_verified":true}`,
+			expectedReason: ReasonDomainDenied,
+		},
+		{
+			name:           "domain not allowed",
+			claims:         `{"email":"user@example.com","email_verified":true}`,
+			expectedReason: ReasonDomainNotAllowed,
+		},
+		{
+			name:           "domain suffix is not a subdomain",
+			claims:         `{"email":"user@notuba.ar","email_verified":true}`,
+			expectedReason: ReasonDomainNotAllowed,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			p := NewPolicy(newRules())
+
+			// When
+			err := p.Evaluate(claims(tc.claims))
+
+			// Then
+			if tc.expectedReason == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			var deniedErr *DeniedError
+			require.True(t, errors.As(err, &deniedErr))
+			require.True(t, errors.Is(err, ErrDenied))
+			require.Equal(t, tc.expectedReason, deniedErr.Reason)
+		})
+	}
+}
+
+func TestPolicy_Evaluate_NoRules(t *testing.T) {
+	// Given
+	p := NewPolicy(Rules{})
+
+	// When
+	err := p.Evaluate(claims(`{"sub":"_sub_"}`))
+
+	// Then
+	require.NoError(t, err)
+}
+
+func TestLoadFile(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "email.json")
+
+	b, _ := json.Marshal(newRules())
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	p, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, newRules(), p.rules)
+}
@@ -0,0 +1,191 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/user"
+)
+
+var ErrDenied = errors.New("hook: login is not allowed")
+
+// Reasons why the built-in hooks deny a login, meant to be shown to clients.
+const (
+	ReasonSuspended        = "user_suspended"
+	ReasonEmailNotVerified = "email_not_verified"
+	ReasonAccountTooOld    = "account_too_old"
+)
+
+// DeniedError is returned by a hook to deny a login. Reason is a code telling
+// why, e.g. ReasonSuspended.
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return "hook: login is not allowed: " + e.Reason
+}
+
+func (e *DeniedError) Is(target error) bool {
+	return target == ErrDenied
+}
+
+type UnmarshalClaims interface {
+	Claims(v interface{}) error
+}
+
+// Login is a user logging in, once its identity is verified and before its
+// token is created, or refreshing its token. Hooks can change Roles and
+// Permissions, and add claims to the token through Claims. On refresh,
+// Identity holds the profile of User, and Roles and Permissions are those of
+// the renewed token.
+type Login struct {
+	Identity    UnmarshalClaims
+	User        user.User
+	Roles       []string
+	Permissions []string
+	Claims      map[string]interface{}
+}
+
+// SetClaim adds the claim name to the token of l.
+func (l *Login) SetClaim(name string, value interface{}) {
+	if l.Claims == nil {
+		l.Claims = make(map[string]interface{})
+	}
+
+	l.Claims[name] = value
+}
+
+// Hook runs on every login. It denies the login returning a DeniedError; any
+// other error fails it.
+type Hook interface {
+	Run(ctx context.Context, login *Login) error
+}
+
+// Func adapts a function into a Hook.
+type Func func(ctx context.Context, login *Login) error
+
+func (f Func) Run(ctx context.Context, login *Login) error {
+	return f(ctx, login)
+}
+
+// Chain runs its hooks in order, stopping at the first one that fails.
+type Chain []Hook
+
+func (c Chain) Run(ctx context.Context, login *Login) error {
+	for _, h := range c {
+		if err := h.Run(ctx, login); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Config enables the built-in hooks. SuspendedUsers are the IDs or emails of
+// the users that cannot log in, and MaxAccountAge, e.g. "720h", the time
+// users can keep logging in since their first login.
+type Config struct {
+	SuspendedUsers       []string `json:"suspended_users"`
+	RequireEmailVerified bool     `json:"require_email_verified"`
+	MaxAccountAge        string   `json:"max_account_age"`
+}
+
+// NewChain returns the built-in hooks enabled by config.
+func NewChain(config Config) (Chain, error) {
+	var chain Chain
+	if len(config.SuspendedUsers) > 0 {
+		chain = append(chain, SuspendedUsers(config.SuspendedUsers...))
+	}
+
+	if config.RequireEmailVerified {
+		chain = append(chain, RequireEmailVerified())
+	}
+
+	if config.MaxAccountAge != "" {
+		age, err := time.ParseDuration(config.MaxAccountAge)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse max account age: %v", err)
+		}
+
+		chain = append(chain, MaxAccountAge(age))
+	}
+
+	return chain, nil
+}
+
+// LoadFile returns the built-in hooks enabled by the JSON config in a file.
+func LoadFile(path string) (Chain, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read hooks config: %v", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("could not unmarshal hooks config: %v", err)
+	}
+
+	return NewChain(config)
+}
+
+// SuspendedUsers denies the login of the users with any of the given IDs or
+// emails.
+func SuspendedUsers(users ...string) Hook {
+	suspended := make(map[string]bool)
+	for _, u := range users {
+		suspended[strings.ToLower(u)] = true
+	}
+
+	return Func(func(ctx context.Context, login *Login) error {
+		if suspended[strings.ToLower(login.User.ID)] || (login.User.Email != "" && suspended[strings.ToLower(login.User.Email)]) {
+			return &DeniedError{Reason: ReasonSuspended}
+		}
+
+		return nil
+	})
+}
+
+// RequireEmailVerified denies the login of users whose ID token does not say
+// their email is verified.
+func RequireEmailVerified() Hook {
+	return Func(func(ctx context.Context, login *Login) error {
+		var claims struct {
+			EmailVerified bool `json:"email_verified"`
+		}
+
+		if err := login.Identity.Claims(&claims); err != nil {
+			return fmt.Errorf("could not fetch claims: %v", err)
+		}
+
+		if !claims.EmailVerified {
+			return &DeniedError{Reason: ReasonEmailNotVerified}
+		}
+
+		return nil
+	})
+}
+
+// MaxAccountAge denies the login of users who logged in for the first time
+// longer than age ago.
+func MaxAccountAge(age time.Duration) Hook {
+	return maxAccountAge{age: age, now: time.Now}
+}
+
+type maxAccountAge struct {
+	age time.Duration
+	now func() time.Time
+}
+
+func (h maxAccountAge) Run(ctx context.Context, login *Login) error {
+	if h.now().Sub(login.User.FirstLoginAt) > h.age {
+		return &DeniedError{Reason: ReasonAccountTooOld}
+	}
+
+	return nil
+}
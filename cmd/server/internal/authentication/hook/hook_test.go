@@ -0,0 +1,175 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/user"
+
+	"github.com/stretchr/testify/require"
+)
+
+type claims []byte
+
+func (c claims) Claims(v interface{}) error {
+	return json.Unmarshal(c, v)
+}
+
+func TestChain_Run(t *testing.T) {
+	// Given
+	var called []string
+	chain := Chain{
+		Func(func(ctx context.Context, login *Login) error {
+			called = append(called, "first")
+			login.Roles = append(login.Roles, "beta")
+			login.SetClaim("plan", "pro")
+			return nil
+		}),
+		Func(func(ctx context.Context, login *Login) error {
+			called = append(called, "second")
+			return nil
+		}),
+	}
+
+	login := Login{Roles: []string{"admin"}}
+
+	// When
+	err := chain.Run(context.Background(), &login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, []string{"first", "second"}, called)
+	require.Equal(t, Login{
+		Roles:  []string{"admin", "beta"},
+		Claims: map[string]interface{}{"plan": "pro"},
+	}, login)
+}
+
+func TestChain_Run_DeniedError(t *testing.T) {
+	// Given
+	chain := Chain{
+		Func(func(ctx context.Context, login *Login) error {
+			return &DeniedError{Reason: "_reason_"}
+		}),
+		Func(func(ctx context.Context, login *Login) error {
+			t.Fatal("next hook must not run")
+			return nil
+		}),
+	}
+
+	// When
+	err := chain.Run(context.Background(), &Login{})
+
+	// Then
+	require.True(t, errors.Is(err, ErrDenied))
+	require.EqualError(t, err, "hook: login is not allowed: _reason_")
+}
+
+func TestBuiltInHooks(t *testing.T) {
+	now := time.Unix(100000, 0)
+
+	tt := []struct {
+		name           string
+		hook           Hook
+		login          Login
+		expectedReason string
+	}{
+		{
+			name:  "not suspended user",
+			hook:  SuspendedUsers("_id_", "banned@example.com"),
+			login: Login{User: user.User{ID: "_other_id_", Email: "user@example.com"}},
+		},
+		{
+			name:           "suspended user id",
+			hook:           SuspendedUsers("_id_", "banned@example.com"),
+			login:          Login{User: user.User{ID: "_id_"}},
+			expectedReason: ReasonSuspended,
+		},
+		{
+			name:           "suspended user email",
+			hook:           SuspendedUsers("_id_", "banned@example.com"),
+			login:          Login{User: user.User{ID: "_other_id_", Email: "Banned@Example.com"}},
+			expectedReason: ReasonSuspended,
+		},
+		{
+			name:  "verified email",
+			hook:  RequireEmailVerified(),
+			login: Login{Identity: claims(`{"email_verified":true}`)},
+		},
+		{
+			name:           "unverified email",
+			hook:           RequireEmailVerified(),
+			login:          Login{Identity: claims(`{"email_verified":false}`)},
+			expectedReason: ReasonEmailNotVerified,
+		},
+		{
+			name:  "new account",
+			hook:  maxAccountAge{age: time.Hour, now: func() time.Time { return now }},
+			login: Login{User: user.User{FirstLoginAt: now.Add(-time.Hour)}},
+		},
+		{
+			name:           "old account",
+			hook:           maxAccountAge{age: time.Hour, now: func() time.Time { return now }},
+			login:          Login{User: user.User{FirstLoginAt: now.Add(-time.Hour - time.Second)}},
+			expectedReason: ReasonAccountTooOld,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// When
+			err := tc.hook.Run(context.Background(), &tc.login)
+
+			// Then
+			if tc.expectedReason == "" {
+				require.NoError(t, err)
+				return
+			}
+
+			var deniedErr *DeniedError
+			require.True(t, errors.As(err, &deniedErr))
+			require.Equal(t, tc.expectedReason, deniedErr.Reason)
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "hooks.json")
+
+	b := []byte(`{"suspended_users":["_id_"],"require_email_verified":true,"max_account_age":"720h"}`)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	chain, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Len(t, chain, 3)
+	require.Equal(t, 720*time.Hour, chain[2].(maxAccountAge).age)
+
+	err = chain.Run(context.Background(), &Login{User: user.User{ID: "_id_"}})
+	require.True(t, errors.Is(err, ErrDenied))
+}
+
+func TestNewChain_InvalidMaxAccountAgeError(t *testing.T) {
+	// When
+	_, err := NewChain(Config{MaxAccountAge: "a year"})
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, `could not parse max account age: time: invalid duration "a year"`)
+}
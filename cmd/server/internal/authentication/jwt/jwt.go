@@ -1,57 +1,273 @@
 package jwt
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
+	"gopkg.in/square/go-jose.v2"
 )
 
 var (
-	ErrNotFound            = errors.New("jwt: resource not found")
-	ErrUnsupportedProvider = errors.New("jwt: unsupported provider")
-	ErrMalformedToken      = errors.New("jwt: malformed token")
-	ErrExpiredToken        = errors.New("jwt: token has expired or is not valid yet")
+	ErrNotFound       = errors.New("jwt: resource not found")
+	ErrMalformedToken = errors.New("jwt: malformed token")
+	ErrExpiredToken   = errors.New("jwt: token has expired or is not valid yet")
+	ErrRevokedToken   = errors.New("jwt: token has been revoked")
+	ErrAudience       = errors.New("jwt: token is not intended for this audience")
 )
 
 type UnmarshalClaims interface {
 	Claims(v interface{}) error
 }
 
+// RevocationList keeps the ids of revoked tokens. Revoke must keep id until
+// expiresAt, once the token can no longer be accepted.
+type RevocationList interface {
+	Revoke(id string, expiresAt time.Time) error
+	IsRevoked(id string) (bool, error)
+}
+
+// Clock tells the time tokens are issued and validated at.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type Option func(t *JWT)
+
+// WithClock makes JWT read the time from clock instead of the system clock.
+func WithClock(clock Clock) Option {
+	return func(t *JWT) {
+		t.clock = clock
+	}
+}
+
+// WithStrictClaims rejects tokens carrying claims that CClaims does not
+// declare, instead of ignoring them.
+func WithStrictClaims() Option {
+	return func(t *JWT) {
+		t.strict = true
+	}
+}
+
+// WithLeeway tolerates a clock skew of leeway when validating the exp, nbf and
+// iat claims.
+func WithLeeway(leeway time.Duration) Option {
+	return func(t *JWT) {
+		t.leeway = leeway
+	}
+}
+
 type JWT struct {
-	signingKey    string
-	signingMethod jwt.SigningMethod
+	keyring     *Keyring
+	revocations RevocationList
+	issuer      string
+	audience    []string
+	ttl         time.Duration
+	clock       Clock
+	leeway      time.Duration
+	strict      bool
 }
 
-func NewJWT(signingKey string) *JWT {
-	return &JWT{
-		signingKey:    signingKey,
-		signingMethod: jwt.SigningMethodHS256,
+// NewJWT creates tokens signed with the current key of keyring. Tokens are
+// issued by issuer for audience and are valid for ttl, regardless of the
+// lifetime of the upstream identity they are created from.
+func NewJWT(keyring *Keyring, revocations RevocationList, issuer string, audience []string, ttl time.Duration, opts ...Option) *JWT {
+	t := &JWT{
+		keyring:     keyring,
+		revocations: revocations,
+		issuer:      strings.TrimSuffix(issuer, "/"),
+		audience:    audience,
+		ttl:         ttl,
+		clock:       systemClock{},
 	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
 }
 
-func (t *JWT) Create(v UnmarshalClaims, subject string) (string, error) {
+// Create signs a token for the user described by v, whose internal ID is
+// subject, granting it roles and permissions. extra is set as the ext claim.
+func (t *JWT) Create(v UnmarshalClaims, subject string, roles []string, permissions []string, extra map[string]interface{}) (string, error) {
 	if subject == "" {
 		return "", ErrNotFound
 	}
 
-	if !strings.Contains(subject, "google-oauth2") && !strings.Contains(subject, "windowslive") {
-		return "", fmt.Errorf("%w: got: (%s), want: (google-oauth2 and windowslive)", ErrUnsupportedProvider, subject)
+	return t.create(v, subject, roles, permissions, extra)
+}
+
+// CreateForClient creates a token for a confidential client authenticating on
+// its own behalf. Its subject is the client id and it carries no metadata.
+func (t *JWT) CreateForClient(clientID string, scopes []string) (string, error) {
+	if clientID == "" {
+		return "", ErrNotFound
+	}
+
+	claims, err := t.newClaims(clientID)
+	if err != nil {
+		return "", err
+	}
+
+	claims.Scope = strings.Join(scopes, " ")
+
+	return t.sign(claims)
+}
+
+func (t *JWT) create(v UnmarshalClaims, subject string, roles []string, permissions []string, extra map[string]interface{}) (string, error) {
+	upstream, err := extractClaims(v)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := t.newClaims(subject)
+	if err != nil {
+		return "", err
+	}
+
+	claims.Metadata = &MetaData{
+		Name:      upstream.Name,
+		Email:     upstream.Email,
+		AvatarURL: upstream.Picture,
+	}
+	claims.Upstream = &Upstream{
+		Subject:   upstream.Sub,
+		Issuer:    upstream.Iss,
+		Audience:  upstream.Aud,
+		ExpiresAt: upstream.Exp,
+		IssuedAt:  upstream.Iat,
+	}
+	claims.Roles = roles
+	claims.Permissions = permissions
+	if len(extra) > 0 {
+		claims.Extra = extra
 	}
 
-	return t.create(v)
+	return t.sign(claims)
 }
 
-func (t *JWT) create(v UnmarshalClaims) (string, error) {
-	customClaims, err := extractClaims(v)
+// newClaims returns the registered claims of a new token for subject, issued
+// now and valid for the TTL of t.
+func (t *JWT) newClaims(subject string) (CClaims, error) {
+	id, err := newID()
+	if err != nil {
+		return CClaims{}, err
+	}
+
+	now := t.clock.Now()
+
+	return CClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Issuer:    t.issuer,
+			Subject:   subject,
+			Audience:  t.audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.ttl)),
+		},
+	}, nil
+}
+
+// RenewableClaims returns the claims of signedToken to be renewed. The
+// signature of signedToken is verified but its expiration is not, so expired
+// tokens can be renewed.
+func (t *JWT) RenewableClaims(signedToken string) (CClaims, error) {
+	var claims CClaims
+	if _, err := t.parser().ParseWithClaims(signedToken, &claims, t.keyFunc); err != nil {
+		return CClaims{}, fmt.Errorf("could not handle jwt: %w: %v", ErrMalformedToken, err)
+	}
+
+	return claims, nil
+}
+
+// Renew signs claims again with a fresh issue time, keeping the lifetime of
+// the token they were taken from.
+func (t *JWT) Renew(claims CClaims) (string, error) {
+	id, err := newID()
 	if err != nil {
 		return "", err
 	}
 
-	token := jwt.NewWithClaims(t.signingMethod, customClaims)
+	lifetime := t.ttl
+	if claims.ExpiresAt != nil && claims.IssuedAt != nil {
+		lifetime = claims.ExpiresAt.Sub(claims.IssuedAt.Time)
+	}
+
+	now := t.clock.Now()
+	claims.ID = id
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(lifetime))
+
+	return t.sign(claims)
+}
+
+// Revoke adds the ID of signedToken to the revocation list until the token
+// is no longer accepted, that is its expiration plus the leeway. Tokens past
+// that point are not recorded.
+func (t *JWT) Revoke(signedToken string) error {
+	var claims CClaims
+	if _, err := t.parser().ParseWithClaims(signedToken, &claims, t.keyFunc); err != nil {
+		return fmt.Errorf("could not handle jwt: %w: %v", ErrMalformedToken, err)
+	}
+
+	if claims.ID == "" {
+		return fmt.Errorf("could not find token id: %w", ErrMalformedToken)
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil
+	}
+
+	// Claims accepts tokens until their exp plus the leeway, so they must stay
+	// revoked until then.
+	acceptedUntil := claims.ExpiresAt.Add(t.leeway)
+	if acceptedUntil.Before(t.clock.Now()) {
+		return nil
+	}
+
+	if err := t.revocations.Revoke(claims.ID, acceptedUntil); err != nil {
+		return fmt.Errorf("could not revoke token: %v", err)
+	}
+
+	return nil
+}
 
-	signedToken, err := token.SignedString([]byte(t.signingKey))
+// KeySet returns the public keys that verify the tokens signed by t.
+func (t *JWT) KeySet() jose.JSONWebKeySet {
+	keySet := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	for _, key := range t.keyring.Keys() {
+		if key.IsPublishable() {
+			keySet.Keys = append(keySet.Keys, key.jwk())
+		}
+	}
+
+	return keySet
+}
+
+func (t *JWT) sign(claims jwt.Claims) (string, error) {
+	key := t.keyring.Current()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	signedToken, err := token.SignedString(key.signingKey)
 	if err != nil {
 		return "", fmt.Errorf("could not sign token: %v", err)
 	}
@@ -59,13 +275,61 @@ func (t *JWT) create(v UnmarshalClaims) (string, error) {
 	return signedToken, nil
 }
 
-type Claims interface {
-	jwt.Claims
+// parser only accepts tokens signed with the algorithms of the keyring of t.
+// Claims are validated separately, against the clock of t.
+func (t *JWT) parser() *jwt.Parser {
+	return jwt.NewParser(jwt.WithValidMethods(t.keyring.Algorithms()), jwt.WithoutClaimsValidation())
 }
 
+func (t *JWT) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, exist := t.keyring.Lookup(kid)
+	if !exist {
+		return nil, fmt.Errorf("unexpected key id: %s", kid)
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+	}
+
+	return key.verificationKey, nil
+}
+
+// CClaims are the claims of the tokens created by JWT. Metadata, Upstream,
+// Roles, Permissions and Extra are only set for tokens issued to users, and
+// Scope only for tokens issued to clients. Extra holds the claims added on
+// login by the post-authentication hooks.
 type CClaims struct {
-	Metadata MetaData `json:"metadata"`
-	jwt.StandardClaims
+	Metadata    *MetaData              `json:"metadata,omitempty"`
+	Upstream    *Upstream              `json:"upstream,omitempty"`
+	Roles       []string               `json:"roles,omitempty"`
+	Permissions []string               `json:"permissions,omitempty"`
+	Extra       map[string]interface{} `json:"ext,omitempty"`
+	Scope       string                 `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Scopes returns the scopes granted by c, which are the scope of a client
+// token or the permissions of a user token.
+func (c CClaims) Scopes() []string {
+	return append(strings.Fields(c.Scope), c.Permissions...)
+}
+
+// HasScopes reports whether c grants every scope in scopes.
+func (c CClaims) HasScopes(scopes ...string) bool {
+	granted := make(map[string]bool)
+	for _, scope := range c.Scopes() {
+		granted[scope] = true
+	}
+
+	for _, scope := range scopes {
+		if !granted[scope] {
+			return false
+		}
+	}
+
+	return true
 }
 
 type MetaData struct {
@@ -74,50 +338,116 @@ type MetaData struct {
 	AvatarURL string `json:"avatar_url"`
 }
 
-func extractClaims(v UnmarshalClaims) (CClaims, error) {
-	var claims struct {
-		Aud     string `json:"aud"`
-		Exp     int64  `json:"exp"`
-		Iat     int64  `json:"iat"`
-		Iss     string `json:"iss"`
-		Name    string `json:"name"`
-		Email   string `json:"email"`
-		Picture string `json:"picture"`
-		Sub     string `json:"sub"`
-	}
+// Upstream holds the registered claims of the identity provider token a user
+// token was created from. They are informational only and never validated.
+// Subject identifies the user at the provider, unlike the sub of the token,
+// which is its internal ID.
+type Upstream struct {
+	Subject   string           `json:"sub,omitempty"`
+	Issuer    string           `json:"iss,omitempty"`
+	Audience  jwt.ClaimStrings `json:"aud,omitempty"`
+	ExpiresAt int64            `json:"exp,omitempty"`
+	IssuedAt  int64            `json:"iat,omitempty"`
+}
+
+type upstreamClaims struct {
+	Aud     jwt.ClaimStrings `json:"aud"`
+	Exp     int64            `json:"exp"`
+	Iat     int64            `json:"iat"`
+	Iss     string           `json:"iss"`
+	Name    string           `json:"name"`
+	Email   string           `json:"email"`
+	Picture string           `json:"picture"`
+	Sub     string           `json:"sub"`
+}
 
+func extractClaims(v UnmarshalClaims) (upstreamClaims, error) {
+	var claims upstreamClaims
 	if err := v.Claims(&claims); err != nil {
-		return CClaims{}, fmt.Errorf("could not fetch claims: %v", err)
+		return upstreamClaims{}, fmt.Errorf("could not fetch claims: %v", err)
 	}
 
-	return CClaims{
-		Metadata: MetaData{
-			Name:      claims.Name,
-			Email:     claims.Email,
-			AvatarURL: claims.Picture,
-		},
-		StandardClaims: jwt.StandardClaims{
-			Audience:  claims.Aud,
-			ExpiresAt: claims.Exp,
-			IssuedAt:  claims.Iat,
-			Issuer:    claims.Iss,
-			Subject:   claims.Sub,
-		},
-	}, nil
+	return claims, nil
 }
 
-func (t *JWT) Claims(signedToken string) (Claims, error) {
-	token, err := jwt.Parse(signedToken, func(token *jwt.Token) (interface{}, error) { return []byte(t.signingKey), nil })
-	if err != nil {
-		var hErr *jwt.ValidationError
-		if errors.As(err, &hErr) {
-			if hErr.Errors == jwt.ValidationErrorExpired || hErr.Errors == jwt.ValidationErrorNotValidYet {
-				return nil, ErrExpiredToken
-			}
+// Claims returns the claims of signedToken once its signature, lifetime and
+// revocation are verified. With WithStrictClaims, tokens carrying claims that
+// CClaims does not declare are rejected as malformed.
+func (t *JWT) Claims(signedToken string) (CClaims, error) {
+	var claims CClaims
+	if _, err := t.parser().ParseWithClaims(signedToken, &claims, t.keyFunc); err != nil {
+		return CClaims{}, fmt.Errorf("could not handle jwt: %w: %v", ErrMalformedToken, err)
+	}
+
+	if t.strict {
+		if err := decodeStrict(signedToken); err != nil {
+			return CClaims{}, fmt.Errorf("could not handle jwt: %w: %v", ErrMalformedToken, err)
 		}
+	}
+
+	if err := t.validate(claims); err != nil {
+		return CClaims{}, err
+	}
+
+	if claims.ID != "" {
+		revoked, err := t.revocations.IsRevoked(claims.ID)
+		if err != nil {
+			return CClaims{}, fmt.Errorf("could not check token revocation: %v", err)
+		}
+
+		if revoked {
+			return CClaims{}, ErrRevokedToken
+		}
+	}
+
+	return claims, nil
+}
+
+// validate checks the time based claims against the clock of t, tolerating a
+// skew of up to its leeway, and that the token is intended for one of the
+// audiences of t.
+func (t *JWT) validate(claims CClaims) error {
+	now := t.clock.Now()
+
+	if !claims.VerifyExpiresAt(now.Add(-t.leeway), false) ||
+		!claims.VerifyNotBefore(now.Add(t.leeway), false) ||
+		!claims.VerifyIssuedAt(now.Add(t.leeway), false) {
+		return ErrExpiredToken
+	}
+
+	if len(t.audience) == 0 {
+		return nil
+	}
+
+	for _, audience := range t.audience {
+		if claims.VerifyAudience(audience, true) {
+			return nil
+		}
+	}
+
+	return ErrAudience
+}
+
+// decodeStrict decodes the payload of signedToken, failing on claims that
+// CClaims does not declare.
+func decodeStrict(signedToken string) error {
+	payload, err := jwt.DecodeSegment(strings.Split(signedToken, ".")[1])
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+
+	var claims CClaims
+	return decoder.Decode(&claims)
+}
 
-		return nil, fmt.Errorf("could not handle jwt: %w: %v", ErrMalformedToken, err)
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate token id: %v", err)
 	}
 
-	return token.Claims, nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,149 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+	"gopkg.in/square/go-jose.v2"
+)
+
+var ErrUnsupportedKey = errors.New("jwt: unsupported key")
+
+type Key struct {
+	ID              string
+	Method          jwt.SigningMethod
+	signingKey      interface{}
+	verificationKey interface{}
+}
+
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{
+		ID:              id,
+		Method:          jwt.SigningMethodHS256,
+		signingKey:      secret,
+		verificationKey: secret,
+	}
+}
+
+// LoadPEMKey reads a PEM encoded RSA, ECDSA or Ed25519 private key. When id is
+// empty the key is identified by its RFC 7638 thumbprint.
+func LoadPEMKey(id string, path string) (Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, fmt.Errorf("could not read key file: %v", err)
+	}
+
+	return ParsePEMKey(id, b)
+}
+
+func ParsePEMKey(id string, b []byte) (Key, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return Key{}, fmt.Errorf("could not decode pem block: %w", ErrUnsupportedKey)
+	}
+
+	privateKey, err := parsePrivateKey(block)
+	if err != nil {
+		return Key{}, err
+	}
+
+	return NewKey(id, privateKey)
+}
+
+func NewKey(id string, privateKey crypto.Signer) (Key, error) {
+	var method jwt.SigningMethod
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return Key{}, fmt.Errorf("%w: unsupported curve: %s", ErrUnsupportedKey, k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return Key{}, fmt.Errorf("%w: got: (%T)", ErrUnsupportedKey, privateKey)
+	}
+
+	key := Key{
+		ID:              id,
+		Method:          method,
+		signingKey:      privateKey,
+		verificationKey: privateKey.Public(),
+	}
+
+	if key.ID == "" {
+		jwk := key.jwk()
+
+		thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return Key{}, fmt.Errorf("could not compute key thumbprint: %v", err)
+		}
+
+		key.ID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+
+	return key, nil
+}
+
+// IsPublishable reports whether the verification key can be shared, which is
+// false for symmetric keys.
+func (k Key) IsPublishable() bool {
+	_, symmetric := k.verificationKey.([]byte)
+	return !symmetric
+}
+
+func (k Key) jwk() jose.JSONWebKey {
+	return jose.JSONWebKey{
+		Key:       k.verificationKey,
+		KeyID:     k.ID,
+		Algorithm: k.Method.Alg(),
+		Use:       "sig",
+	}
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var (
+		privateKey interface{}
+		err        error
+	)
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%w: unsupported pem block: %s", ErrUnsupportedKey, block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key: %w: %v", ErrUnsupportedKey, err)
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: got: (%T)", ErrUnsupportedKey, privateKey)
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,237 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newPEM(t *testing.T, privateKey crypto.Signer, pkcs8 bool) []byte {
+	t.Helper()
+
+	if pkcs8 {
+		b, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})
+	}
+
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+	}
+
+	t.Fatalf("unsupported key %T", privateKey)
+	return nil
+}
+
+func newSigners(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]crypto.Signer{"RS256": rsaKey, "ES256": ecKey, "EdDSA": edKey}
+}
+
+func TestParsePEMKey(t *testing.T) {
+	for alg, signer := range newSigners(t) {
+		for _, pkcs8 := range []bool{true, false} {
+			if _, ed := signer.(ed25519.PrivateKey); ed && !pkcs8 {
+				continue
+			}
+
+			t.Run(alg, func(t *testing.T) {
+				// Given
+				b := newPEM(t, signer, pkcs8)
+
+				// When
+				key, err := ParsePEMKey("", b)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Then
+				require.Equal(t, alg, key.Method.Alg())
+				require.NotEmpty(t, key.ID)
+				require.True(t, key.IsPublishable())
+			})
+		}
+	}
+}
+
+func TestParsePEMKey_UnsupportedKeyError(t *testing.T) {
+	tt := []struct {
+		name string
+		b    []byte
+	}{
+		{
+			name: "not pem",
+			b:    []byte("not pem"),
+		},
+		{
+			name: "public key",
+			b:    pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("key")}),
+		},
+		{
+			name: "invalid private key",
+			b:    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// When
+			_, err := ParsePEMKey("", tc.b)
+
+			// Then
+			require.True(t, errors.Is(err, ErrUnsupportedKey))
+		})
+	}
+}
+
+func TestJWT_AsymmetricKeys(t *testing.T) {
+	for alg, signer := range newSigners(t) {
+		t.Run(alg, func(t *testing.T) {
+			// Given
+			key, err := NewKey("_kid_", signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			revocations := revocationListMock{}
+			revocations.On("IsRevoked", "_jti_").Return(false, nil)
+
+			jwt_ := NewJWT(NewKeyring(key, time.Hour), &revocations, "https://auth.example.com", []string{"https://api.example.com"}, time.Hour)
+
+			signedToken, err := jwt_.sign(CClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "_jti_", Subject: "_sub_", Audience: jwt.ClaimStrings{"https://api.example.com"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			claims, err := jwt_.Claims(signedToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			token, _, err := new(jwt.Parser).ParseUnverified(signedToken, jwt.MapClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			require.Equal(t, "_sub_", claims.Subject)
+			require.Equal(t, alg, token.Header["alg"])
+			require.Equal(t, "_kid_", token.Header["kid"])
+		})
+	}
+}
+
+func TestJWT_Claims_UnexpectedKeyError(t *testing.T) {
+	signers := newSigners(t)
+
+	rsaKey, err := NewKey("_kid_", signers["RS256"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := NewKey("_kid_", signers["ES256"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name string
+		key  Key
+	}{
+		{
+			name: "different signing method",
+			key:  ecKey,
+		},
+		{
+			name: "different key id",
+			key:  Key{ID: "_another_kid_", Method: rsaKey.Method, signingKey: rsaKey.signingKey},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			signedToken, err := NewJWT(NewKeyring(tc.key, time.Hour), &revocationListMock{}, "https://auth.example.com", []string{"https://api.example.com"}, time.Hour).sign(CClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jwt_ := NewJWT(NewKeyring(rsaKey, time.Hour), &revocationListMock{}, "https://auth.example.com", []string{"https://api.example.com"}, time.Hour)
+
+			// When
+			_, err = jwt_.Claims(signedToken)
+
+			// Then
+			require.True(t, errors.Is(err, ErrMalformedToken))
+		})
+	}
+}
+
+func TestJWT_KeySet(t *testing.T) {
+	// Given
+	key, err := NewKey("_kid_", newSigners(t)["ES256"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwt_ := NewJWT(NewKeyring(key, time.Hour), &revocationListMock{}, "https://auth.example.com", []string{"https://api.example.com"}, time.Hour)
+
+	// When
+	keySet := jwt_.KeySet()
+
+	// Then
+	require.Len(t, keySet.Keys, 1)
+	require.Equal(t, "_kid_", keySet.Keys[0].KeyID)
+	require.Equal(t, "ES256", keySet.Keys[0].Algorithm)
+	require.True(t, keySet.Keys[0].IsPublic())
+}
+
+func TestJWT_KeySet_HMACKeyIsNotPublished(t *testing.T) {
+	// Given
+	jwt_ := NewJWT(NewKeyring(NewHMACKey("_kid_", []byte("signingKey")), time.Hour), &revocationListMock{}, "https://auth.example.com", []string{"https://api.example.com"}, time.Hour)
+
+	// When
+	keySet := jwt_.KeySet()
+
+	// Then
+	require.Empty(t, keySet.Keys)
+}
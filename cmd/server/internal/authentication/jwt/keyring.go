@@ -0,0 +1,140 @@
+package jwt
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type retiredKey struct {
+	Key
+	retiredAt time.Time
+}
+
+// Keyring holds the key used to sign new tokens along with the keys it
+// replaced, which keep verifying tokens until maxTokenLifetime has passed since
+// they were retired.
+type Keyring struct {
+	current          Key
+	previous         []retiredKey
+	maxTokenLifetime time.Duration
+	mu               sync.RWMutex
+}
+
+func NewKeyring(current Key, maxTokenLifetime time.Duration, previous ...Key) *Keyring {
+	now := time.Now()
+
+	retired := make([]retiredKey, 0, len(previous))
+	for _, key := range previous {
+		retired = append(retired, retiredKey{Key: key, retiredAt: now})
+	}
+
+	return &Keyring{
+		current:          current,
+		previous:         retired,
+		maxTokenLifetime: maxTokenLifetime,
+	}
+}
+
+func (r *Keyring) Current() Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current
+}
+
+func (r *Keyring) Lookup(id string) (Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.current.ID == id {
+		return r.current, true
+	}
+
+	for _, key := range r.previous {
+		if key.ID == id && r.isActive(key) {
+			return key.Key, true
+		}
+	}
+
+	return Key{}, false
+}
+
+func (r *Keyring) Keys() []Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []Key{r.current}
+	for _, key := range r.previous {
+		if r.isActive(key) {
+			keys = append(keys, key.Key)
+		}
+	}
+
+	return keys
+}
+
+// Algorithms returns the signing algorithms of the keys that verify tokens,
+// which are the only ones accepted when parsing a token.
+func (r *Keyring) Algorithms() []string {
+	var algs []string
+	seen := make(map[string]bool)
+	for _, key := range r.Keys() {
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+
+	return algs
+}
+
+// Rotate makes next the signing key and retires the current one. Keys retired
+// longer than the maximum token lifetime ago are dropped. Rotating to the
+// current key does nothing.
+func (r *Keyring) Rotate(next Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if next.ID == r.current.ID {
+		return
+	}
+
+	previous := []retiredKey{{Key: r.current, retiredAt: time.Now()}}
+	for _, key := range r.previous {
+		if key.ID != next.ID && r.isActive(key) {
+			previous = append(previous, key)
+		}
+	}
+
+	r.current = next
+	r.previous = previous
+}
+
+// RotateEvery rotates the keyring with the keys returned by next on every
+// interval until ctx is done.
+func (r *Keyring) RotateEvery(ctx context.Context, interval time.Duration, next func() (Key, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			key, err := next()
+			if err != nil {
+				log.Printf("could not rotate signing key: %v", err)
+				continue
+			}
+
+			r.Rotate(key)
+		}
+	}
+}
+
+func (r *Keyring) isActive(key retiredKey) bool {
+	return time.Since(key.retiredAt) <= r.maxTokenLifetime
+}
@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring_Rotate(t *testing.T) {
+	// Given
+	first := NewHMACKey("first", []byte("first"))
+	second := NewHMACKey("second", []byte("second"))
+
+	r := NewKeyring(first, time.Hour)
+
+	// When
+	r.Rotate(second)
+
+	// Then
+	key, exist := r.Lookup("first")
+
+	require.Equal(t, second, r.Current())
+	require.True(t, exist)
+	require.Equal(t, first, key)
+	require.Equal(t, []Key{second, first}, r.Keys())
+}
+
+func TestKeyring_Rotate_CurrentKey(t *testing.T) {
+	// Given
+	first := NewHMACKey("first", []byte("first"))
+
+	r := NewKeyring(first, time.Hour)
+
+	// When
+	r.Rotate(first)
+
+	// Then
+	require.Equal(t, first, r.Current())
+	require.Equal(t, []Key{first}, r.Keys())
+}
+
+func TestKeyring_Rotate_RetiresKeysAfterMaxTokenLifetime(t *testing.T) {
+	// Given
+	first := NewHMACKey("first", []byte("first"))
+	second := NewHMACKey("second", []byte("second"))
+	third := NewHMACKey("third", []byte("third"))
+
+	r := NewKeyring(first, time.Hour)
+	r.Rotate(second)
+	r.previous[0].retiredAt = time.Now().Add(-2 * time.Hour)
+
+	// When
+	_, existBeforeRotation := r.Lookup("first")
+	r.Rotate(third)
+
+	// Then
+	_, exist := r.Lookup("first")
+
+	require.False(t, existBeforeRotation)
+	require.False(t, exist)
+	require.Equal(t, []Key{third, second}, r.Keys())
+}
+
+func TestKeyring_PreviousKeys(t *testing.T) {
+	// Given
+	current := NewHMACKey("current", []byte("current"))
+	previous := NewHMACKey("previous", []byte("previous"))
+
+	// When
+	r := NewKeyring(current, time.Hour, previous)
+
+	// Then
+	key, exist := r.Lookup("previous")
+
+	require.True(t, exist)
+	require.Equal(t, previous, key)
+}
+
+func TestKeyring_Lookup_UnknownKey(t *testing.T) {
+	// Given
+	r := NewKeyring(NewHMACKey("current", []byte("current")), time.Hour)
+
+	// When
+	_, exist := r.Lookup("unknown")
+
+	// Then
+	require.False(t, exist)
+}
+
+func TestKeyring_RotateEvery(t *testing.T) {
+	// Given
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := NewHMACKey("first", []byte("first"))
+	second := NewHMACKey("second", []byte("second"))
+
+	r := NewKeyring(first, time.Hour)
+
+	next := func() (Key, error) {
+		return second, nil
+	}
+
+	// When
+	go r.RotateEvery(ctx, time.Millisecond, next)
+
+	// Then
+	require.Eventually(t, func() bool { return r.Current().ID == "second" }, time.Second, time.Millisecond)
+}
+
+func TestJWT_Claims_VerifiesTokensSignedByRetiredKeys(t *testing.T) {
+	// Given
+	revocations := revocationListMock{}
+	revocations.On("IsRevoked", "_jti_").Return(false, nil)
+
+	r := NewKeyring(NewHMACKey("first", []byte("first")), time.Hour)
+	jwt_ := NewJWT(r, &revocations, "https://auth.example.com", []string{"https://api.example.com"}, time.Hour)
+
+	signedToken, err := jwt_.sign(CClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "_jti_", Audience: jwt.ClaimStrings{"https://api.example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Rotate(NewHMACKey("second", []byte("second")))
+
+	// When
+	_, err = jwt_.Claims(signedToken)
+
+	// Then
+	require.NoError(t, err)
+}
+
+func TestJWT_Claims_RejectsTokensSignedByExpiredKeys(t *testing.T) {
+	// Given
+	r := NewKeyring(NewHMACKey("first", []byte("first")), time.Hour)
+	jwt_ := NewJWT(r, &revocationListMock{}, "https://auth.example.com", []string{"https://api.example.com"}, time.Hour)
+
+	signedToken, err := jwt_.sign(CClaims{RegisteredClaims: jwt.RegisteredClaims{ID: "_jti_", Audience: jwt.ClaimStrings{"https://api.example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Rotate(NewHMACKey("second", []byte("second")))
+	r.previous[0].retiredAt = time.Now().Add(-2 * time.Hour)
+
+	// When
+	_, err = jwt_.Claims(signedToken)
+
+	// Then
+	require.True(t, errors.Is(err, ErrMalformedToken))
+}
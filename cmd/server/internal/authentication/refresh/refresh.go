@@ -0,0 +1,149 @@
+package refresh
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("refresh: resource not found")
+	ErrExpiredToken = errors.New("refresh: token has expired")
+	ErrReusedToken  = errors.New("refresh: token has already been used")
+)
+
+type Token struct {
+	ID          string
+	Family      string
+	AccessToken string
+	ExpiresAt   time.Time
+	Rotated     bool
+}
+
+type Storage interface {
+	Get(id string) (Token, error)
+	Save(token Token) error
+	DeleteFamily(family string) error
+}
+
+type Refresher struct {
+	storage Storage
+	ttl     time.Duration
+	mu      sync.Mutex
+}
+
+func NewRefresher(storage Storage, ttl time.Duration) *Refresher {
+	return &Refresher{
+		storage: storage,
+		ttl:     ttl,
+	}
+}
+
+func (r *Refresher) Create(accessToken string) (string, error) {
+	family, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	return r.create(family, accessToken)
+}
+
+func (r *Refresher) Lookup(refreshToken string) (Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lookup(refreshToken)
+}
+
+// Rotate marks refreshToken as used and issues a new token of the same family
+// bound to accessToken.
+func (r *Refresher) Rotate(refreshToken string, accessToken string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, err := r.lookup(refreshToken)
+	if err != nil {
+		return "", err
+	}
+
+	token.Rotated = true
+	if err := r.storage.Save(token); err != nil {
+		return "", fmt.Errorf("could not save token: %v", err)
+	}
+
+	return r.create(token.Family, accessToken)
+}
+
+func (r *Refresher) Revoke(refreshToken string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, err := r.storage.Get(hash(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	return r.storage.DeleteFamily(token.Family)
+}
+
+// lookup returns the token identified by refreshToken. A token that was
+// already rotated means it has been replayed, so its whole family is revoked.
+func (r *Refresher) lookup(refreshToken string) (Token, error) {
+	token, err := r.storage.Get(hash(refreshToken))
+	if err != nil {
+		return Token{}, err
+	}
+
+	if token.Rotated {
+		if err := r.storage.DeleteFamily(token.Family); err != nil {
+			return Token{}, fmt.Errorf("could not revoke token family: %v", err)
+		}
+
+		return Token{}, ErrReusedToken
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		return Token{}, ErrExpiredToken
+	}
+
+	return token, nil
+}
+
+func (r *Refresher) create(family string, accessToken string) (string, error) {
+	refreshToken, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	token := Token{
+		ID:          hash(refreshToken),
+		Family:      family,
+		AccessToken: accessToken,
+		ExpiresAt:   time.Now().Add(r.ttl),
+	}
+
+	if err := r.storage.Save(token); err != nil {
+		return "", fmt.Errorf("could not save token: %v", err)
+	}
+
+	return refreshToken, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hash(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
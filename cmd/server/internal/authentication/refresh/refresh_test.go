@@ -0,0 +1,247 @@
+package refresh
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefresher_Create(t *testing.T) {
+	// Given
+	storage := NewMemoryStorage()
+	r := NewRefresher(storage, time.Hour)
+
+	// When
+	refreshToken, err := r.Create("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	token, err := r.Lookup(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	require.Equal(t, "token", token.AccessToken)
+	require.NotContains(t, storage.tokens, refreshToken)
+}
+
+func TestRefresher_Lookup_NotFoundError(t *testing.T) {
+	// Given
+	r := NewRefresher(NewMemoryStorage(), time.Hour)
+
+	// When
+	_, err := r.Lookup("unknown")
+
+	// Then
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestRefresher_Lookup_ExpiredTokenError(t *testing.T) {
+	// Given
+	r := NewRefresher(NewMemoryStorage(), -time.Second)
+
+	refreshToken, err := r.Create("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	_, err = r.Lookup(refreshToken)
+
+	// Then
+	require.Equal(t, ErrExpiredToken, err)
+}
+
+func TestRefresher_Rotate(t *testing.T) {
+	// Given
+	r := NewRefresher(NewMemoryStorage(), time.Hour)
+
+	refreshToken, err := r.Create("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	newRefreshToken, err := r.Rotate(refreshToken, "new token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	oldToken, _ := r.storage.Get(hash(refreshToken))
+	newToken, err := r.Lookup(newRefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	require.NotEqual(t, refreshToken, newRefreshToken)
+	require.True(t, oldToken.Rotated)
+	require.Equal(t, oldToken.Family, newToken.Family)
+	require.Equal(t, "new token", newToken.AccessToken)
+}
+
+func TestRefresher_Rotate_ReusedTokenRevokesFamily(t *testing.T) {
+	// Given
+	r := NewRefresher(NewMemoryStorage(), time.Hour)
+
+	refreshToken, err := r.Create("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRefreshToken, err := r.Rotate(refreshToken, "new token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	_, err = r.Rotate(refreshToken, "another token")
+
+	// Then
+	require.Equal(t, ErrReusedToken, err)
+
+	_, err = r.Lookup(newRefreshToken)
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestRefresher_Revoke(t *testing.T) {
+	// Given
+	r := NewRefresher(NewMemoryStorage(), time.Hour)
+
+	refreshToken, err := r.Create("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRefreshToken, err := r.Rotate(refreshToken, "new token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	err = r.Revoke(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	_, err = r.Lookup(newRefreshToken)
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func storages(t *testing.T) map[string]Storage {
+	sqlite, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "refresh_tokens.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		sqlite.Close()
+	})
+
+	return map[string]Storage{
+		"memory": NewMemoryStorage(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestStorage_Save(t *testing.T) {
+	for name, storage := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			token := Token{ID: "_id_", Family: "_family_", AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour).Round(0).UTC()}
+			if err := storage.Save(token); err != nil {
+				t.Fatal(err)
+			}
+
+			token.Rotated = true
+
+			// When
+			err := storage.Save(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			saved, err := storage.Get("_id_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			require.Equal(t, token, saved)
+		})
+	}
+}
+
+func TestStorage_Save_PrunesExpiredFamilies(t *testing.T) {
+	for name, storage := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			tokens := []Token{
+				{ID: "_expired_", Family: "_expired_family_", ExpiresAt: time.Now().Add(-time.Hour)},
+				{ID: "_live_", Family: "_live_family_", ExpiresAt: time.Now().Add(time.Hour)},
+				{ID: "_rotated_", Family: "_live_family_", ExpiresAt: time.Now().Add(-time.Hour), Rotated: true},
+			}
+
+			for _, token := range tokens {
+				if err := storage.Save(token); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// When
+			err := storage.Save(Token{ID: "_new_", Family: "_new_family_", ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			_, err = storage.Get("_expired_")
+			require.Equal(t, ErrNotFound, err)
+
+			for _, id := range []string{"_rotated_", "_live_", "_new_"} {
+				_, err := storage.Get(id)
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSQLiteStorage_PersistsTokens(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "refresh_tokens.db")
+
+	storage, err := NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshToken, err := NewRefresher(storage, time.Hour).Create("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = NewSQLiteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	// When
+	token, err := NewRefresher(storage, time.Hour).Lookup(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, "token", token.AccessToken)
+}
@@ -0,0 +1,113 @@
+package refresh
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id           TEXT PRIMARY KEY,
+		family       TEXT NOT NULL,
+		access_token TEXT NOT NULL,
+		expires_at   INTEGER NOT NULL,
+		rotated      INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS refresh_tokens_family ON refresh_tokens (family)`
+
+// SQLiteStorage stores tokens in a SQLite database, so they outlive restarts
+// and can be shared by several instances. Like MemoryStorage, families whose
+// tokens have all expired are dropped every time a token is saved.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+// NewSQLiteStorage opens the database at path, creating it and its schema when
+// missing.
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open refresh token database: %v", err)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create refresh token schema: %v", err)
+	}
+
+	return &SQLiteStorage{db: db}, nil
+}
+
+func (s *SQLiteStorage) Get(id string) (Token, error) {
+	var (
+		token     Token
+		expiresAt int64
+	)
+
+	row := s.db.QueryRow(`SELECT id, family, access_token, expires_at, rotated FROM refresh_tokens WHERE id = ?`, id)
+	if err := row.Scan(&token.ID, &token.Family, &token.AccessToken, &expiresAt, &token.Rotated); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Token{}, ErrNotFound
+		}
+
+		return Token{}, fmt.Errorf("could not get refresh token: %v", err)
+	}
+
+	token.ExpiresAt = time.Unix(0, expiresAt).UTC()
+	return token, nil
+}
+
+func (s *SQLiteStorage) Save(token Token) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not save refresh token: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM refresh_tokens WHERE family IN (
+			SELECT family FROM refresh_tokens GROUP BY family HAVING MAX(expires_at) < ?
+		)`,
+		time.Now().UnixNano(),
+	)
+	if err != nil {
+		return fmt.Errorf("could not prune refresh tokens: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (id, family, access_token, expires_at, rotated)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			family = excluded.family,
+			access_token = excluded.access_token,
+			expires_at = excluded.expires_at,
+			rotated = excluded.rotated`,
+		token.ID, token.Family, token.AccessToken, token.ExpiresAt.UnixNano(), token.Rotated,
+	)
+	if err != nil {
+		return fmt.Errorf("could not save refresh token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not save refresh token: %v", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteFamily(family string) error {
+	if _, err := s.db.Exec(`DELETE FROM refresh_tokens WHERE family = ?`, family); err != nil {
+		return fmt.Errorf("could not delete refresh token family: %v", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,72 @@
+package refresh
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps tokens in memory. Families whose tokens have all
+// expired are dropped every time a token is saved.
+type MemoryStorage struct {
+	tokens map[string]Token
+	mu     sync.RWMutex
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		tokens: make(map[string]Token),
+	}
+}
+
+func (s *MemoryStorage) Get(id string) (Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, exist := s.tokens[id]
+	if !exist {
+		return Token{}, ErrNotFound
+	}
+
+	return token, nil
+}
+
+func (s *MemoryStorage) Save(token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	s.tokens[token.ID] = token
+	return nil
+}
+
+func (s *MemoryStorage) DeleteFamily(family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.tokens {
+		if token.Family == family {
+			delete(s.tokens, id)
+		}
+	}
+
+	return nil
+}
+
+// prune drops the families whose tokens have all expired. Families with a live
+// token are kept whole, so replaying one of their rotated tokens still revokes
+// them.
+func (s *MemoryStorage) prune() {
+	expiresAt := make(map[string]time.Time)
+	for _, token := range s.tokens {
+		if token.ExpiresAt.After(expiresAt[token.Family]) {
+			expiresAt[token.Family] = token.ExpiresAt
+		}
+	}
+
+	now := time.Now()
+	for id, token := range s.tokens {
+		if expiresAt[token.Family].Before(now) {
+			delete(s.tokens, id)
+		}
+	}
+}
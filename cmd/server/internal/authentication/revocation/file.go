@@ -0,0 +1,116 @@
+package revocation
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// FileList is a revocation list persisted as one JSON entry per line. Expired
+// entries are dropped from the file every time it is opened.
+type FileList struct {
+	memory *MemoryList
+	file   *os.File
+	mu     sync.Mutex
+}
+
+func NewFileList(path string) (*FileList, error) {
+	entries, err := readEntries(path)
+	if err != nil {
+		return nil, err
+	}
+
+	memory := NewMemoryList()
+
+	var b []byte
+	for _, e := range entries {
+		if e.ExpiresAt.Before(time.Now()) {
+			continue
+		}
+
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal entry: %v", err)
+		}
+
+		b = append(append(b, line...), '\n')
+		memory.entries[e.ID] = e.ExpiresAt
+	}
+
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return nil, fmt.Errorf("could not write revocation list: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open revocation list: %v", err)
+	}
+
+	return &FileList{
+		memory: memory,
+		file:   file,
+	}, nil
+}
+
+func (l *FileList) Revoke(id string, expiresAt time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	line, err := json.Marshal(entry{ID: id, ExpiresAt: expiresAt})
+	if err != nil {
+		return fmt.Errorf("could not marshal entry: %v", err)
+	}
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write revocation list: %v", err)
+	}
+
+	return l.memory.Revoke(id, expiresAt)
+}
+
+func (l *FileList) IsRevoked(id string) (bool, error) {
+	return l.memory.IsRevoked(id)
+}
+
+func (l *FileList) Close() error {
+	return l.file.Close()
+}
+
+func readEntries(path string) ([]entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("could not open revocation list: %v", err)
+	}
+	defer file.Close()
+
+	var entries []entry
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("could not unmarshal entry: %v", err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read revocation list: %v", err)
+	}
+
+	return entries, nil
+}
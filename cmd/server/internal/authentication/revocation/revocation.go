@@ -0,0 +1,42 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+type MemoryList struct {
+	entries map[string]time.Time
+	mu      sync.Mutex
+}
+
+func NewMemoryList() *MemoryList {
+	return &MemoryList{
+		entries: make(map[string]time.Time),
+	}
+}
+
+func (l *MemoryList) Revoke(id string, expiresAt time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[id] = expiresAt
+	return nil
+}
+
+func (l *MemoryList) IsRevoked(id string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expiresAt, exist := l.entries[id]
+	if !exist {
+		return false, nil
+	}
+
+	if expiresAt.Before(time.Now()) {
+		delete(l.entries, id)
+		return false, nil
+	}
+
+	return true, nil
+}
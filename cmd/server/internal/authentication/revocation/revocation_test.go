@@ -0,0 +1,98 @@
+package revocation
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryList_IsRevoked(t *testing.T) {
+	tt := []struct {
+		name      string
+		expiresAt time.Time
+		revoked   bool
+	}{
+		{
+			name:      "live entry",
+			expiresAt: time.Now().Add(time.Hour),
+			revoked:   true,
+		},
+		{
+			name:      "expired entry",
+			expiresAt: time.Now().Add(-time.Hour),
+			revoked:   false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			l := NewMemoryList()
+			if err := l.Revoke("_jti_", tc.expiresAt); err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			revoked, err := l.IsRevoked("_jti_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.Equal(t, tc.revoked, revoked)
+		})
+	}
+}
+
+func TestMemoryList_IsRevoked_UnknownID(t *testing.T) {
+	// Given
+	l := NewMemoryList()
+
+	// When
+	revoked, err := l.IsRevoked("_jti_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.False(t, revoked)
+}
+
+func TestFileList_PersistsEntries(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "revocations")
+
+	l, err := NewFileList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Revoke("_live_", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Revoke("_expired_", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	l, err = NewFileList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Then
+	live, _ := l.IsRevoked("_live_")
+	expired, _ := l.IsRevoked("_expired_")
+
+	require.True(t, live)
+	require.False(t, expired)
+	require.Len(t, l.memory.entries, 1)
+}
@@ -0,0 +1,135 @@
+package role
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type UnmarshalClaims interface {
+	Claims(v interface{}) error
+}
+
+// Mapping grants roles locally, by subject or by the domain of the email, and
+// expands every role into its permissions.
+type Mapping struct {
+	Subjects     map[string][]string `json:"subjects"`
+	EmailDomains map[string][]string `json:"email_domains"`
+	Permissions  map[string][]string `json:"permissions"`
+}
+
+// Access holds the roles and permissions granted to a user.
+type Access struct {
+	Roles       []string
+	Permissions []string
+}
+
+// Resolver computes the access of a user from the namespaced roles and
+// permissions claims of its ID token, as set by Auth0 rules and actions, and
+// from a local mapping.
+type Resolver struct {
+	namespace string
+	mapping   Mapping
+}
+
+func NewResolver(namespace string, mapping Mapping) *Resolver {
+	return &Resolver{
+		namespace: namespace,
+		mapping:   mapping,
+	}
+}
+
+// LoadFile builds a resolver whose mapping is read from a JSON file.
+func LoadFile(namespace string, path string) (*Resolver, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read role mapping: %v", err)
+	}
+
+	var mapping Mapping
+	if err := json.Unmarshal(b, &mapping); err != nil {
+		return nil, fmt.Errorf("could not unmarshal role mapping: %v", err)
+	}
+
+	return NewResolver(namespace, mapping), nil
+}
+
+func (r *Resolver) Resolve(v UnmarshalClaims) (Access, error) {
+	var claims map[string]json.RawMessage
+	if err := v.Claims(&claims); err != nil {
+		return Access{}, fmt.Errorf("could not fetch claims: %v", err)
+	}
+
+	var sub, email string
+	_ = json.Unmarshal(claims["sub"], &sub)
+	_ = json.Unmarshal(claims["email"], &email)
+
+	roles := newSet()
+	permissions := newSet()
+
+	if r.namespace != "" {
+		var upstreamRoles, upstreamPermissions []string
+		if err := unmarshalOptional(claims[r.namespace+"roles"], &upstreamRoles); err != nil {
+			return Access{}, fmt.Errorf("could not unmarshal roles claim: %v", err)
+		}
+
+		if err := unmarshalOptional(claims[r.namespace+"permissions"], &upstreamPermissions); err != nil {
+			return Access{}, fmt.Errorf("could not unmarshal permissions claim: %v", err)
+		}
+
+		roles.add(upstreamRoles...)
+		permissions.add(upstreamPermissions...)
+	}
+
+	roles.add(r.mapping.Subjects[sub]...)
+	if i := strings.LastIndex(email, "@"); i != -1 {
+		roles.add(r.mapping.EmailDomains[strings.ToLower(email[i+1:])]...)
+	}
+
+	for role := range roles {
+		permissions.add(r.mapping.Permissions[role]...)
+	}
+
+	return Access{
+		Roles:       roles.list(),
+		Permissions: permissions.list(),
+	}, nil
+}
+
+func unmarshalOptional(b json.RawMessage, v interface{}) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+type set map[string]bool
+
+func newSet() set {
+	return make(set)
+}
+
+func (s set) add(values ...string) {
+	for _, value := range values {
+		s[value] = true
+	}
+}
+
+// list returns the values sorted, or nil when the set is empty so the claim is
+// left out of the token.
+func (s set) list() []string {
+	if len(s) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(s))
+	for value := range s {
+		values = append(values, value)
+	}
+
+	sort.Strings(values)
+	return values
+}
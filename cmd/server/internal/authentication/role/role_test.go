@@ -0,0 +1,124 @@
+package role
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type claims []byte
+
+func (c claims) Claims(v interface{}) error {
+	return json.Unmarshal(c, v)
+}
+
+func newMapping() Mapping {
+	return Mapping{
+		Subjects:     map[string][]string{"google-oauth2|1234": {"admin"}},
+		EmailDomains: map[string][]string{"dc.uba.ar": {"student"}},
+		Permissions: map[string][]string{
+			"admin":   {"courses:read", "courses:write"},
+			"student": {"courses:read"},
+		},
+	}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	tt := []struct {
+		name     string
+		claims   string
+		expected Access
+	}{
+		{
+			name:   "namespaced claims",
+			claims: `{"sub":"google-oauth2|1","email":"user@example.com","https://example.com/roles":["teacher"],"https://example.com/permissions":["grades:write"]}`,
+			expected: Access{
+				Roles:       []string{"teacher"},
+				Permissions: []string{"grades:write"},
+			},
+		},
+		{
+			name:   "mapped subject",
+			claims: `{"sub":"google-oauth2|1234","email":"user@example.com"}`,
+			expected: Access{
+				Roles:       []string{"admin"},
+				Permissions: []string{"courses:read", "courses:write"},
+			},
+		},
+		{
+			name:   "mapped email domain",
+			claims: `{"sub":"google-oauth2|1","email":"user@DC.UBA.AR"}`,
+			expected: Access{
+				Roles:       []string{"student"},
+				Permissions: []string{"courses:read"},
+			},
+		},
+		{
+			name:   "namespaced and mapped claims",
+			claims: `{"sub":"google-oauth2|1234","email":"user@dc.uba.ar","https://example.com/roles":["admin"]}`,
+			expected: Access{
+				Roles:       []string{"admin", "student"},
+				Permissions: []string{"courses:read", "courses:write"},
+			},
+		},
+		{
+			name:     "no access",
+			claims:   `{"sub":"google-oauth2|1","email":"user@example.com","roles":["admin"]}`,
+			expected: Access{},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			r := NewResolver("https://example.com/", newMapping())
+
+			// When
+			access, err := r.Resolve(claims(tc.claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.Equal(t, tc.expected, access)
+		})
+	}
+}
+
+func TestResolver_Resolve_InvalidClaimError(t *testing.T) {
+	// Given
+	r := NewResolver("https://example.com/", newMapping())
+
+	// When
+	_, err := r.Resolve(claims(`{"sub":"_sub_","https://example.com/roles":"admin"}`))
+
+	// Then
+	require.EqualError(t, err, "could not unmarshal roles claim: json: cannot unmarshal string into Go value of type []string")
+}
+
+func TestLoadFile(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "roles.json")
+
+	b, _ := json.Marshal(newMapping())
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	r, err := LoadFile("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	access, err := r.Resolve(claims(`{"sub":"google-oauth2|1234"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	require.Equal(t, []string{"admin"}, access.Roles)
+}
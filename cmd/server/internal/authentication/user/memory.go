@@ -0,0 +1,90 @@
+package user
+
+import (
+	"strings"
+	"sync"
+)
+
+type MemoryRepository struct {
+	users      map[string]User
+	identities map[Identity]string
+	mu         sync.RWMutex
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		users:      make(map[string]User),
+		identities: make(map[Identity]string),
+	}
+}
+
+func (r *MemoryRepository) Get(id string) (User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, exist := r.users[id]
+	if !exist {
+		return User{}, ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (r *MemoryRepository) FindByIdentity(identity Identity) (User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, exist := r.identities[identity]
+	if !exist {
+		return User{}, ErrNotFound
+	}
+
+	return r.users[id], nil
+}
+
+func (r *MemoryRepository) FindByEmail(email string) (User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var (
+		found User
+		exist bool
+	)
+
+	for _, user := range r.users {
+		if !user.EmailVerified || !strings.EqualFold(user.Email, email) {
+			continue
+		}
+
+		if !exist || user.FirstLoginAt.Before(found.FirstLoginAt) {
+			found, exist = user, true
+		}
+	}
+
+	if !exist {
+		return User{}, ErrNotFound
+	}
+
+	return found, nil
+}
+
+func (r *MemoryRepository) Upsert(user User) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, exist := r.users[user.ID]; exist {
+		user.FirstLoginAt = stored.FirstLoginAt
+		for _, identity := range user.Identities {
+			stored = stored.Link(identity)
+		}
+
+		user.Identities = stored.Identities
+	}
+
+	for _, identity := range user.Identities {
+		r.identities[identity] = user.ID
+	}
+
+	r.users[user.ID] = user
+	return user, nil
+}
@@ -0,0 +1,205 @@
+package user
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// migrations are applied in order to bring the schema of a database up to
+// date, PRAGMA user_version being the number of them already applied.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id             TEXT PRIMARY KEY,
+		name           TEXT NOT NULL,
+		email          TEXT NOT NULL,
+		avatar_url     TEXT NOT NULL,
+		provider       TEXT NOT NULL,
+		first_login_at INTEGER NOT NULL,
+		last_login_at  INTEGER NOT NULL
+	)`,
+	`ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE identities (
+		provider TEXT NOT NULL,
+		subject  TEXT NOT NULL,
+		user_id  TEXT NOT NULL REFERENCES users (id),
+		PRIMARY KEY (provider, subject)
+	);
+	CREATE INDEX identities_user_id ON identities (user_id);
+	INSERT INTO identities (provider, subject, user_id) SELECT provider, id, id FROM users`,
+}
+
+// SQLiteRepository stores users in a SQLite database. Login times are kept
+// with nanosecond precision, in UTC.
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepository opens the database at path, creating it and its schema
+// when missing.
+func NewSQLiteRepository(path string) (*SQLiteRepository, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open user database: %v", err)
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create user schema: %v", err)
+	}
+
+	return &SQLiteRepository{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) Get(id string) (User, error) {
+	var (
+		user         User
+		firstLoginAt int64
+		lastLoginAt  int64
+	)
+
+	row := r.db.QueryRow(`SELECT id, name, email, email_verified, avatar_url, provider, first_login_at, last_login_at FROM users WHERE id = ?`, id)
+	if err := row.Scan(&user.ID, &user.Name, &user.Email, &user.EmailVerified, &user.AvatarURL, &user.Provider, &firstLoginAt, &lastLoginAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNotFound
+		}
+
+		return User{}, fmt.Errorf("could not get user: %v", err)
+	}
+
+	user.FirstLoginAt = time.Unix(0, firstLoginAt).UTC()
+	user.LastLoginAt = time.Unix(0, lastLoginAt).UTC()
+
+	identities, err := r.identities(id)
+	if err != nil {
+		return User{}, err
+	}
+
+	user.Identities = identities
+	return user, nil
+}
+
+func (r *SQLiteRepository) identities(id string) ([]Identity, error) {
+	rows, err := r.db.Query(`SELECT provider, subject FROM identities WHERE user_id = ? ORDER BY rowid`, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get user identities: %v", err)
+	}
+
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		var identity Identity
+		if err := rows.Scan(&identity.Provider, &identity.Subject); err != nil {
+			return nil, fmt.Errorf("could not get user identities: %v", err)
+		}
+
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get user identities: %v", err)
+	}
+
+	return identities, nil
+}
+
+func (r *SQLiteRepository) FindByIdentity(identity Identity) (User, error) {
+	return r.find(`SELECT user_id FROM identities WHERE provider = ? AND subject = ?`, identity.Provider, identity.Subject)
+}
+
+func (r *SQLiteRepository) FindByEmail(email string) (User, error) {
+	return r.find(`SELECT id FROM users WHERE email = ? COLLATE NOCASE AND email_verified = 1 ORDER BY first_login_at LIMIT 1`, email)
+}
+
+func (r *SQLiteRepository) find(query string, args ...interface{}) (User, error) {
+	var id string
+	if err := r.db.QueryRow(query, args...).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNotFound
+		}
+
+		return User{}, fmt.Errorf("could not find user: %v", err)
+	}
+
+	return r.Get(id)
+}
+
+func (r *SQLiteRepository) Upsert(user User) (User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return User{}, fmt.Errorf("could not upsert user: %v", err)
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO users (id, name, email, email_verified, avatar_url, provider, first_login_at, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name,
+			email = excluded.email,
+			email_verified = excluded.email_verified,
+			avatar_url = excluded.avatar_url,
+			provider = excluded.provider,
+			last_login_at = excluded.last_login_at`,
+		user.ID, user.Name, user.Email, user.EmailVerified, user.AvatarURL, user.Provider, user.FirstLoginAt.UnixNano(), user.LastLoginAt.UnixNano(),
+	)
+	if err != nil {
+		return User{}, fmt.Errorf("could not upsert user: %v", err)
+	}
+
+	for _, identity := range user.Identities {
+		_, err := tx.Exec(`
+			INSERT INTO identities (provider, subject, user_id) VALUES (?, ?, ?)
+			ON CONFLICT (provider, subject) DO UPDATE SET user_id = excluded.user_id`,
+			identity.Provider, identity.Subject, user.ID,
+		)
+		if err != nil {
+			return User{}, fmt.Errorf("could not upsert user identity: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return User{}, fmt.Errorf("could not upsert user: %v", err)
+	}
+
+	return r.Get(user.ID)
+}
+
+func (r *SQLiteRepository) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,119 @@
+package user
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotFound = errors.New("user: resource not found")
+
+type UnmarshalClaims interface {
+	Claims(v interface{}) error
+}
+
+// Identity is the account of a user at an identity provider.
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+// User is the record kept for everyone who logged in. ID is the opaque
+// internal ID of the user, a random UUID given on its first login, and
+// Identities are all the identities linked to it. Users stored before IDs were
+// generated keep the subject of their first identity as ID. Provider is the
+// provider of the last login.
+type User struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name,omitempty"`
+	Email         string     `json:"email,omitempty"`
+	EmailVerified bool       `json:"email_verified"`
+	AvatarURL     string     `json:"avatar_url,omitempty"`
+	Provider      string     `json:"provider,omitempty"`
+	Identities    []Identity `json:"identities"`
+	FirstLoginAt  time.Time  `json:"first_login_at"`
+	LastLoginAt   time.Time  `json:"last_login_at"`
+}
+
+// Repository stores users. Upsert creates the user on its first login and
+// otherwise updates its profile and last login, keeping the first login and
+// adding the identities it does not have yet. FindByEmail only finds users
+// whose email is verified.
+type Repository interface {
+	Get(id string) (User, error)
+	FindByIdentity(identity Identity) (User, error)
+	FindByEmail(email string) (User, error)
+	Upsert(user User) (User, error)
+}
+
+// New builds the user that logged in at loginAt through provider from the
+// claims of its identity, as if it were its first login, giving it a new ID.
+func New(provider string, v UnmarshalClaims, loginAt time.Time) (User, error) {
+	var claims struct {
+		Sub           string `json:"sub"`
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Picture       string `json:"picture"`
+	}
+
+	if err := v.Claims(&claims); err != nil {
+		return User{}, fmt.Errorf("could not fetch claims: %v", err)
+	}
+
+	if claims.Sub == "" {
+		return User{}, fmt.Errorf("could not find subject: %w", ErrNotFound)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return User{}, err
+	}
+
+	return User{
+		ID:            id,
+		Name:          claims.Name,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		AvatarURL:     claims.Picture,
+		Provider:      provider,
+		Identities:    []Identity{{Provider: provider, Subject: claims.Sub}},
+		FirstLoginAt:  loginAt,
+		LastLoginAt:   loginAt,
+	}, nil
+}
+
+// HasIdentity reports whether identity is linked to u.
+func (u User) HasIdentity(identity Identity) bool {
+	for _, i := range u.Identities {
+		if i == identity {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Link returns u with identity linked to it.
+func (u User) Link(identity Identity) User {
+	if u.HasIdentity(identity) {
+		return u
+	}
+
+	u.Identities = append(append([]Identity(nil), u.Identities...), identity)
+	return u
+}
+
+// newID returns a random (version 4) UUID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate user id: %v", err)
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,220 @@
+package user
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type claims []byte
+
+func (c claims) Claims(v interface{}) error {
+	return json.Unmarshal(c, v)
+}
+
+func repositories(t *testing.T) map[string]Repository {
+	sqlite, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		sqlite.Close()
+	})
+
+	return map[string]Repository{
+		"memory": NewMemoryRepository(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestNew(t *testing.T) {
+	// Given
+	loginAt := time.Unix(1000, 0)
+	c := claims(`{"sub":"google-oauth2|1234","name":"_name_","email":"_email_","email_verified":true,"picture":"_picture_"}`)
+
+	// When
+	user, err := New("google", c, loginAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, user.ID)
+
+	other, err := New("google", c, loginAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	require.NotEqual(t, user.ID, other.ID)
+
+	user.ID = ""
+	require.Equal(t, User{
+		Name:          "_name_",
+		Email:         "_email_",
+		EmailVerified: true,
+		AvatarURL:     "_picture_",
+		Provider:      "google",
+		Identities:    []Identity{{Provider: "google", Subject: "google-oauth2|1234"}},
+		FirstLoginAt:  loginAt,
+		LastLoginAt:   loginAt,
+	}, user)
+}
+
+func TestNew_MissingSubjectError(t *testing.T) {
+	// When
+	_, err := New("google", claims(`{"name":"_name_"}`), time.Now())
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "could not find subject: user: resource not found")
+}
+
+func TestRepository_Upsert(t *testing.T) {
+	for name, repository := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			firstLoginAt := time.Unix(1000, 0).UTC()
+			lastLoginAt := time.Unix(2000, 0).UTC()
+
+			if _, err := repository.Upsert(User{
+				ID:           "_id_",
+				Name:         "_name_",
+				Email:        "_email_",
+				Provider:     "google",
+				Identities:   []Identity{{Provider: "google", Subject: "_id_"}},
+				FirstLoginAt: firstLoginAt,
+				LastLoginAt:  firstLoginAt,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			user, err := repository.Upsert(User{
+				ID:            "_id_",
+				Name:          "_new_name_",
+				Email:         "_email_",
+				EmailVerified: true,
+				AvatarURL:     "_picture_",
+				Provider:      "github",
+				Identities:    []Identity{{Provider: "github", Subject: "_github_id_"}},
+				FirstLoginAt:  lastLoginAt,
+				LastLoginAt:   lastLoginAt,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			expected := User{
+				ID:            "_id_",
+				Name:          "_new_name_",
+				Email:         "_email_",
+				EmailVerified: true,
+				AvatarURL:     "_picture_",
+				Provider:      "github",
+				Identities: []Identity{
+					{Provider: "google", Subject: "_id_"},
+					{Provider: "github", Subject: "_github_id_"},
+				},
+				FirstLoginAt: firstLoginAt,
+				LastLoginAt:  lastLoginAt,
+			}
+
+			require.Equal(t, expected, user)
+
+			stored, err := repository.Get("_id_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			require.Equal(t, expected, stored)
+		})
+	}
+}
+
+func TestRepository_Get_NotFoundError(t *testing.T) {
+	for name, repository := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			// When
+			_, err := repository.Get("_id_")
+
+			// Then
+			require.Equal(t, ErrNotFound, err)
+		})
+	}
+}
+
+func TestRepository_FindByIdentity(t *testing.T) {
+	for name, repository := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			loginAt := time.Unix(1000, 0).UTC()
+			expected := User{
+				ID:       "_id_",
+				Provider: "google",
+				Identities: []Identity{
+					{Provider: "google", Subject: "_id_"},
+					{Provider: "github", Subject: "_github_id_"},
+				},
+				FirstLoginAt: loginAt,
+				LastLoginAt:  loginAt,
+			}
+
+			if _, err := repository.Upsert(expected); err != nil {
+				t.Fatal(err)
+			}
+
+			// When
+			user, err := repository.FindByIdentity(Identity{Provider: "github", Subject: "_github_id_"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.Equal(t, expected, user)
+
+			_, err = repository.FindByIdentity(Identity{Provider: "google", Subject: "_github_id_"})
+			require.Equal(t, ErrNotFound, err)
+		})
+	}
+}
+
+func TestRepository_FindByEmail(t *testing.T) {
+	for name, repository := range repositories(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			users := []User{
+				{ID: "_unverified_", Email: "user@example.com", FirstLoginAt: time.Unix(1000, 0).UTC()},
+				{ID: "_first_", Email: "user@example.com", EmailVerified: true, FirstLoginAt: time.Unix(2000, 0).UTC()},
+				{ID: "_second_", Email: "user@example.com", EmailVerified: true, FirstLoginAt: time.Unix(3000, 0).UTC()},
+			}
+
+			for _, user := range users {
+				user.LastLoginAt = user.FirstLoginAt
+				user.Identities = []Identity{{Provider: "google", Subject: user.ID}}
+				if _, err := repository.Upsert(user); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// When
+			user, err := repository.FindByEmail("User@Example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.Equal(t, "_first_", user.ID)
+
+			_, err = repository.FindByEmail("other@example.com")
+			require.Equal(t, ErrNotFound, err)
+		})
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"net/url"
 
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/jwt"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/user"
 	"github.com/mateoferrari97/Kit/web/server"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 )
 
@@ -16,9 +20,21 @@ type Wrapper interface {
 }
 
 type Service interface {
-	CreateAuthentication() (url, state string, err error)
-	VerifyAuthentication(ctx context.Context, code string) (string, error)
-	GetMyInformation(token string) ([]byte, error)
+	CreateAuthentication(provider string) (authentication.Authentication, error)
+	VerifyAuthentication(ctx context.Context, authentication authentication.Authentication, code string) (authentication.Tokens, error)
+	LinkAuthentication(ctx context.Context, authentication authentication.Authentication, code string, link authentication.PendingLink) (authentication.Tokens, error)
+	Refresh(ctx context.Context, refreshToken string) (authentication.Tokens, error)
+	Logout(token string, refreshToken string) error
+	EndSession(provider string, idToken string, postLogoutRedirectURI string) (string, error)
+	ValidateToken(token string) (jwt.CClaims, error)
+	GetProfile(claims jwt.CClaims) (authentication.Profile, error)
+	GetUser(id string) (user.User, error)
+	GetUserInfo(claims jwt.CClaims) ([]byte, error)
+	GetConfiguration() ([]byte, error)
+	GetKeySet() ([]byte, error)
+	CreateClientToken(clientID string, clientSecret string, scope string) (authentication.Tokens, error)
+	Introspect(clientID string, clientSecret string, token string) ([]byte, error)
+	RevokeToken(clientID string, clientSecret string, token string, tokenTypeHint string) error
 }
 
 type Storage interface {
@@ -27,41 +43,93 @@ type Storage interface {
 }
 
 type Handler struct {
-	service Service
-	wrapper Wrapper
-	storage Storage
+	service   Service
+	wrapper   Wrapper
+	storage   Storage
+	redirects *Redirects
 }
 
-func NewHandler(wrapper Wrapper, service Service, storage Storage) *Handler {
+func NewHandler(wrapper Wrapper, service Service, storage Storage, redirects *Redirects) *Handler {
 	return &Handler{
-		service: service,
-		wrapper: wrapper,
-		storage: storage,
+		service:   service,
+		wrapper:   wrapper,
+		storage:   storage,
+		redirects: redirects,
 	}
 }
 
+// Login redirects to the provider named in the path, or to the default one on
+// /login. It must be registered after LoginCallback so /login/{provider} does
+// not shadow /login/callback.
 func (h *Handler) Login(mws ...server.Middleware) {
 	wrapH := func(w http.ResponseWriter, r *http.Request) error {
-		uri, state, err := h.service.CreateAuthentication()
-		if err != nil {
-			return err
+		returnTo := r.URL.Query().Get("return_to")
+		if returnTo != "" && !h.redirects.IsAllowed(returnTo) {
+			return server.NewError("invalid return_to parameter", http.StatusBadRequest)
 		}
 
-		session, err := h.storage.Get(r, "auth-session")
+		return h.authorize(w, r, mux.Vars(r)["provider"], returnTo, nil)
+	}
+
+	h.wrapper.Wrap(http.MethodGet, "/login", wrapH, mws...)
+	h.wrapper.Wrap(http.MethodGet, "/login/{provider}", wrapH, mws...)
+}
+
+// Link redirects to the provider the user already logs in with, so that the
+// identity kept in the link-session by LoginCallback is linked to its account
+// once it logs in again.
+func (h *Handler) Link(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		session, err := h.storage.Get(r, "link-session")
 		if err != nil {
 			return err
 		}
 
-		session.Values["state"] = state
-		if err = session.Save(r, w); err != nil {
-			return err
+		provider, _ := session.Values["provider"].(string)
+		returnTo, _ := session.Values["return_to"].(string)
+		link, ok := pendingLink(session.Values)
+		if provider == "" || !ok {
+			return server.NewError("no identity to link", http.StatusBadRequest)
 		}
 
-		http.Redirect(w, r, uri, http.StatusTemporaryRedirect)
-		return nil
+		return h.authorize(w, r, provider, returnTo, &link)
 	}
 
-	h.wrapper.Wrap(http.MethodGet, "/login", wrapH, mws...)
+	h.wrapper.Wrap(http.MethodGet, "/link", wrapH, mws...)
+}
+
+// authorize redirects to provider, keeping in the auth-session what
+// LoginCallback needs to finish the login and, when not nil, the identity to
+// link to the user once it does. Without link, any identity left to link by an
+// abandoned login is dropped.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, provider string, returnTo string, link *authentication.PendingLink) error {
+	authentication_, err := h.service.CreateAuthentication(provider)
+	if err != nil {
+		if errors.Is(err, authentication.ErrNotFound) {
+			return server.NewError(err.Error(), http.StatusNotFound)
+		}
+
+		return err
+	}
+
+	session, err := h.storage.Get(r, "auth-session")
+	if err != nil {
+		return err
+	}
+
+	session.Values["state"] = authentication_.State
+	session.Values["provider"] = authentication_.Provider
+	session.Values["code_verifier"] = authentication_.CodeVerifier
+	session.Values["nonce"] = authentication_.Nonce
+	session.Values["return_to"] = returnTo
+	setPendingLink(session.Values, link)
+
+	if err = session.Save(r, w); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, authentication_.URL, http.StatusTemporaryRedirect)
+	return nil
 }
 
 func (h *Handler) LoginCallback(mws ...server.Middleware) {
@@ -84,67 +152,502 @@ func (h *Handler) LoginCallback(mws ...server.Middleware) {
 			return server.NewError("invalid code parameter", http.StatusForbidden)
 		}
 
-		token, err := h.service.VerifyAuthentication(r.Context(), r.URL.Query().Get("code"))
+		provider, _ := session.Values["provider"].(string)
+		codeVerifier, _ := session.Values["code_verifier"].(string)
+		nonce, _ := session.Values["nonce"].(string)
+
+		authentication_ := authentication.Authentication{
+			Provider:     provider,
+			State:        r.URL.Query().Get("state"),
+			CodeVerifier: codeVerifier,
+			Nonce:        nonce,
+		}
+
+		returnTo, _ := session.Values["return_to"].(string)
+		link, linking := pendingLink(session.Values)
+
+		var tokens authentication.Tokens
+		if linking {
+			tokens, err = h.service.LinkAuthentication(r.Context(), authentication_, r.URL.Query().Get("code"), link)
+		} else {
+			tokens, err = h.service.VerifyAuthentication(r.Context(), authentication_, r.URL.Query().Get("code"))
+		}
+
 		if err != nil {
-			switch err {
-			case authentication.ErrNotFound:
+			var (
+				linkErr      *authentication.LinkRequiredError
+				forbiddenErr *authentication.ForbiddenError
+			)
+
+			switch {
+			case errors.As(err, &linkErr):
+				return h.requireLink(w, r, linkErr, returnTo)
+			case errors.As(err, &forbiddenErr):
+				return server.RespondJSON(w, forbidden{
+					Error:  server.NewError(err.Error(), http.StatusForbidden),
+					Reason: forbiddenErr.Reason,
+				}, http.StatusForbidden)
+			case errors.Is(err, authentication.ErrNotFound):
 				return server.NewError(err.Error(), http.StatusNotFound)
-			case authentication.ErrVerification:
+			case errors.Is(err, authentication.ErrVerification):
 				return server.NewError(err.Error(), http.StatusForbidden)
 			}
 
 			return err
 		}
 
-		c := &http.Cookie{
-			Name:     "token",
-			Value:    token,
-			HttpOnly: true,
-			Path:     "/",
+		// The ID token is kept apart from the short-lived login session so it
+		// can be sent as id_token_hint when the user logs out.
+		logoutSession, err := h.storage.Get(r, "logout-session")
+		if err != nil {
+			return err
 		}
 
-		http.SetCookie(w, c)
+		logoutSession.Values["provider"] = provider
+		logoutSession.Values["id_token"] = tokens.IDToken
+		if err := logoutSession.Save(r, w); err != nil {
+			return err
+		}
+
+		if linking {
+			if err := h.expireSession(w, r, "link-session"); err != nil {
+				return err
+			}
+		}
+
+		setTokenCookies(w, tokens)
+		http.Redirect(w, r, h.redirects.Resolve(returnTo), http.StatusFound)
+
 		return nil
 	}
 
 	h.wrapper.Wrap(http.MethodGet, "/login/callback", wrapH, mws...)
 }
 
-func (h *Handler) Logout(mws ...server.Middleware) {
+// forbidden is the response of a login or a refresh that is not allowed,
+// telling why in Reason.
+type forbidden struct {
+	*server.Error
+	Reason string `json:"reason"`
+}
+
+// linkRequired is the response of a login whose identity must be linked to
+// an existing user by following LinkURL.
+type linkRequired struct {
+	*server.Error
+	Provider string `json:"provider"`
+	LinkURL  string `json:"link_url"`
+}
+
+// requireLink keeps the identity of err in the link-session, for Link to pick
+// it up, and responds with how to link it.
+func (h *Handler) requireLink(w http.ResponseWriter, r *http.Request, err *authentication.LinkRequiredError, returnTo string) error {
+	session, sErr := h.storage.Get(r, "link-session")
+	if sErr != nil {
+		return sErr
+	}
+
+	session.Values["provider"] = err.Provider
+	session.Values["return_to"] = returnTo
+	setPendingLink(session.Values, &err.Link)
+	if sErr := session.Save(r, w); sErr != nil {
+		return sErr
+	}
+
+	return server.RespondJSON(w, linkRequired{
+		Error:    &server.Error{StatusCode: http.StatusConflict, Code: "link_required", Message: err.Error()},
+		Provider: err.Provider,
+		LinkURL:  "/link",
+	}, http.StatusConflict)
+}
+
+// pendingLink returns the identity to link kept in the values of a session.
+func pendingLink(values map[interface{}]interface{}) (authentication.PendingLink, bool) {
+	provider, _ := values["link_provider"].(string)
+	subject, _ := values["link_subject"].(string)
+	email, _ := values["link_email"].(string)
+	if provider == "" || subject == "" || email == "" {
+		return authentication.PendingLink{}, false
+	}
+
+	return authentication.PendingLink{
+		Identity: user.Identity{Provider: provider, Subject: subject},
+		Email:    email,
+	}, true
+}
+
+// setPendingLink keeps link in the values of a session, or removes the one
+// kept there when nil.
+func setPendingLink(values map[interface{}]interface{}, link *authentication.PendingLink) {
+	if link == nil {
+		delete(values, "link_provider")
+		delete(values, "link_subject")
+		delete(values, "link_email")
+		return
+	}
+
+	values["link_provider"] = link.Identity.Provider
+	values["link_subject"] = link.Identity.Subject
+	values["link_email"] = link.Email
+}
+
+func (h *Handler) expireSession(w http.ResponseWriter, r *http.Request, name string) error {
+	session, err := h.storage.Get(r, name)
+	if err != nil {
+		return err
+	}
+
+	session.Options.MaxAge = -1
+	return session.Save(r, w)
+}
+
+func (h *Handler) RefreshToken(mws ...server.Middleware) {
 	wrapH := func(w http.ResponseWriter, r *http.Request) error {
-		c, err := r.Cookie("token")
+		refreshToken := r.FormValue("refresh_token")
+		if refreshToken == "" {
+			if c, err := r.Cookie("refresh_token"); err == nil {
+				refreshToken = c.Value
+			}
+		}
+
+		if refreshToken == "" {
+			return server.NewError("invalid refresh_token parameter", http.StatusBadRequest)
+		}
+
+		tokens, err := h.service.Refresh(r.Context(), refreshToken)
 		if err != nil {
-			if errors.Is(err, http.ErrNoCookie) {
-				return nil
+			var forbiddenErr *authentication.ForbiddenError
+			switch {
+			case errors.As(err, &forbiddenErr):
+				return server.RespondJSON(w, forbidden{
+					Error:  server.NewError(err.Error(), http.StatusForbidden),
+					Reason: forbiddenErr.Reason,
+				}, http.StatusForbidden)
+			case errors.Is(err, authentication.ErrVerification):
+				return server.NewError(err.Error(), http.StatusUnauthorized)
 			}
 
 			return err
 		}
 
-		c.MaxAge = -1
-		http.SetCookie(w, c)
+		setTokenCookies(w, tokens)
+		w.Header().Set("Cache-Control", "no-store")
+
+		return server.RespondJSON(w, tokens, http.StatusOK)
+	}
+
+	h.wrapper.Wrap(http.MethodPost, "/token/refresh", wrapH, mws...)
+}
+
+// Logout revokes the local tokens and then redirects to the provider so its
+// session ends too; otherwise the next login would silently sign the user
+// back in. Browsers send the refresh token as a cookie, while other clients
+// POST it as the refresh_token form value.
+func (h *Handler) Logout(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		returnTo := r.URL.Query().Get("return_to")
+		if returnTo != "" && !h.redirects.IsAllowed(returnTo) {
+			return server.NewError("invalid return_to parameter", http.StatusBadRequest)
+		}
+
+		if err := h.revokeTokens(w, r); err != nil {
+			return err
+		}
 
+		session, err := h.storage.Get(r, "logout-session")
+		if err != nil {
+			return err
+		}
+
+		provider, _ := session.Values["provider"].(string)
+		idToken, _ := session.Values["id_token"].(string)
+
+		session.Options.MaxAge = -1
+		if err := session.Save(r, w); err != nil {
+			return err
+		}
+
+		postLogoutRedirectURI := h.redirects.Absolute(returnTo)
+
+		endSessionURL, err := h.service.EndSession(provider, idToken, postLogoutRedirectURI)
+		if err != nil && !errors.Is(err, authentication.ErrNotFound) {
+			return err
+		}
+
+		if endSessionURL == "" {
+			endSessionURL = postLogoutRedirectURI
+		}
+
+		http.Redirect(w, r, endSessionURL, http.StatusFound)
 		return nil
 	}
 
 	h.wrapper.Wrap(http.MethodGet, "/logout", wrapH, mws...)
+	h.wrapper.Wrap(http.MethodPost, "/logout", wrapH, mws...)
+}
+
+// revokeTokens revokes the token found by the OptionalAuthenticate middleware,
+// valid or not, along with the refresh token, and expires their cookies.
+func (h *Handler) revokeTokens(w http.ResponseWriter, r *http.Request) error {
+	token, _ := tokenFromContext(r.Context())
+
+	refreshToken := r.PostFormValue("refresh_token")
+	if refreshToken == "" {
+		if c, err := r.Cookie("refresh_token"); err == nil {
+			refreshToken = c.Value
+		}
+	}
+
+	if token == "" && refreshToken == "" {
+		return nil
+	}
+
+	if err := h.service.Logout(token, refreshToken); err != nil {
+		return err
+	}
+
+	expireTokenCookies(w)
+	return nil
 }
 
+// Me describes who the token validated by the Authenticate middleware was
+// issued to, following the schema of authentication.Profile.
 func (h *Handler) Me(mws ...server.Middleware) {
 	wrapH := func(w http.ResponseWriter, r *http.Request) error {
-		token := r.Header.Get("Authorization")
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok {
+			return server.NewError("missing token", http.StatusUnauthorized)
+		}
+
+		profile, err := h.service.GetProfile(claims)
+		if err != nil {
+			return err
+		}
+
+		return server.RespondJSON(w, profile, http.StatusOK)
+	}
+
+	h.wrapper.Wrap(http.MethodGet, "/me", wrapH, mws...)
+}
+
+// User returns the user stored in the user directory with the id in the path,
+// for other services to look users up.
+func (h *Handler) User(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		user_, err := h.service.GetUser(mux.Vars(r)["id"])
+		if err != nil {
+			if errors.Is(err, authentication.ErrNotFound) {
+				return server.NewError(err.Error(), http.StatusNotFound)
+			}
+
+			return err
+		}
+
+		return server.RespondJSON(w, user_, http.StatusOK)
+	}
+
+	h.wrapper.Wrap(http.MethodGet, "/users/{id}", wrapH, mws...)
+}
+
+// UserInfo returns the claims of the owner of the token validated by the
+// Authenticate middleware, as the OIDC userinfo endpoint does.
+func (h *Handler) UserInfo(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			return server.NewError("missing token", http.StatusUnauthorized)
+		}
+
+		userInfo, err := h.service.GetUserInfo(claims)
+		if err != nil {
+			return err
+		}
+
+		return server.RespondJSON(w, userInfo, http.StatusOK)
+	}
+
+	h.wrapper.Wrap(http.MethodGet, "/userinfo", wrapH, mws...)
+}
+
+// refreshCookiePaths are the only paths browsers send the refresh token
+// cookie to: where it is renewed and where it is revoked on logout.
+var refreshCookiePaths = []string{"/token/refresh", "/logout"}
+
+func setTokenCookies(w http.ResponseWriter, tokens authentication.Tokens) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    tokens.AccessToken,
+		HttpOnly: true,
+		Path:     "/",
+	})
+
+	for _, path := range refreshCookiePaths {
+		http.SetCookie(w, &http.Cookie{
+			Name:     "refresh_token",
+			Value:    tokens.RefreshToken,
+			HttpOnly: true,
+			Path:     path,
+		})
+	}
+}
 
-		myInformation, err := h.service.GetMyInformation(token)
+// expireTokenCookies removes the cookies set by setTokenCookies, which must be
+// expired on the same paths they were set on.
+func expireTokenCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		HttpOnly: true,
+		Path:     "/",
+		MaxAge:   -1,
+	})
+
+	for _, path := range refreshCookiePaths {
+		http.SetCookie(w, &http.Cookie{
+			Name:     "refresh_token",
+			HttpOnly: true,
+			Path:     path,
+			MaxAge:   -1,
+		})
+	}
+}
+
+func (h *Handler) JWKS(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		keySet, err := h.service.GetKeySet()
 		if err != nil {
-			if errors.Is(err, authentication.ErrParse) {
+			return err
+		}
+
+		return server.RespondJSON(w, keySet, http.StatusOK)
+	}
+
+	h.wrapper.Wrap(http.MethodGet, "/.well-known/jwks.json", wrapH, mws...)
+}
+
+// AuthorizationServerMetadata serves the RFC 8414 metadata of this service.
+// It is not an OpenID provider, as users log in through the providers it
+// delegates to, so it publishes no OpenID configuration.
+func (h *Handler) AuthorizationServerMetadata(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		configuration, err := h.service.GetConfiguration()
+		if err != nil {
+			return err
+		}
+
+		return server.RespondJSON(w, configuration, http.StatusOK)
+	}
+
+	h.wrapper.Wrap(http.MethodGet, "/.well-known/oauth-authorization-server", wrapH, mws...)
+}
+
+// Token issues access tokens to confidential clients. Only the client
+// credentials grant is supported; users get their tokens through /login.
+func (h *Handler) Token(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		if r.PostFormValue("grant_type") != "client_credentials" {
+			return server.NewError("unsupported grant_type parameter", http.StatusBadRequest)
+		}
+
+		clientID, clientSecret := clientCredentials(r)
+
+		tokens, err := h.service.CreateClientToken(clientID, clientSecret, r.PostFormValue("scope"))
+		if err != nil {
+			switch {
+			case errors.Is(err, authentication.ErrVerification):
+				w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+				return server.NewError(err.Error(), http.StatusUnauthorized)
+			case errors.Is(err, authentication.ErrScope):
+				return server.NewError(err.Error(), http.StatusBadRequest)
+			}
+
+			return err
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+
+		return server.RespondJSON(w, tokens, http.StatusOK)
+	}
+
+	h.wrapper.Wrap(http.MethodPost, "/oauth/token", wrapH, mws...)
+}
+
+// Introspect describes a token to resource servers following RFC 7662. The
+// client authenticates with HTTP Basic or with client_id and client_secret in
+// the body.
+func (h *Handler) Introspect(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		clientID, clientSecret := clientCredentials(r)
+
+		token := r.PostFormValue("token")
+		if token == "" {
+			return server.NewError("invalid token parameter", http.StatusBadRequest)
+		}
+
+		introspection, err := h.service.Introspect(clientID, clientSecret, token)
+		if err != nil {
+			if errors.Is(err, authentication.ErrVerification) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+				return server.NewError(err.Error(), http.StatusUnauthorized)
+			}
+
+			return err
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		return server.RespondJSON(w, introspection, http.StatusOK)
+	}
+
+	h.wrapper.Wrap(http.MethodPost, "/oauth/introspect", wrapH, mws...)
+}
+
+// Revoke invalidates an access or refresh token following RFC 7009. It
+// responds 200 even when the token is unknown, so clients cannot probe for
+// valid tokens.
+func (h *Handler) Revoke(mws ...server.Middleware) {
+	wrapH := func(w http.ResponseWriter, r *http.Request) error {
+		clientID, clientSecret := clientCredentials(r)
+
+		token := r.PostFormValue("token")
+		if token == "" {
+			return server.NewError("invalid token parameter", http.StatusBadRequest)
+		}
+
+		if err := h.service.RevokeToken(clientID, clientSecret, token, r.PostFormValue("token_type_hint")); err != nil {
+			switch {
+			case errors.Is(err, authentication.ErrVerification):
+				w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+				return server.NewError(err.Error(), http.StatusUnauthorized)
+			case errors.Is(err, authentication.ErrNotOwner):
 				return server.NewError(err.Error(), http.StatusForbidden)
 			}
 
 			return err
 		}
 
-		return server.RespondJSON(w, myInformation, http.StatusOK)
+		return server.RespondJSON(w, nil, http.StatusOK)
 	}
 
-	h.wrapper.Wrap(http.MethodGet, "/me", wrapH, mws...)
+	h.wrapper.Wrap(http.MethodPost, "/oauth/revoke", wrapH, mws...)
+}
+
+// clientCredentials reads the client credentials from the Authorization
+// header, whose values are form encoded as RFC 6749 requires, or from the body.
+func clientCredentials(r *http.Request) (string, string) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		return r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+
+	if id, err := url.QueryUnescape(clientID); err == nil {
+		clientID = id
+	}
+
+	if secret, err := url.QueryUnescape(clientSecret); err == nil {
+		clientSecret = secret
+	}
+
+	return clientID, clientSecret
 }
@@ -4,13 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/jwt"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/user"
 	"github.com/mateoferrari97/Kit/web/server"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -28,21 +35,81 @@ type serviceMock struct {
 	mock.Mock
 }
 
-func (s *serviceMock) CreateAuthentication() (url, state string, err error) {
-	args := s.Called()
-	return args.String(0), args.String(1), args.Error(2)
+func (s *serviceMock) CreateAuthentication(provider string) (authentication.Authentication, error) {
+	args := s.Called(provider)
+	return args.Get(0).(authentication.Authentication), args.Error(1)
+}
+
+func (s *serviceMock) VerifyAuthentication(ctx context.Context, authentication_ authentication.Authentication, code string) (authentication.Tokens, error) {
+	args := s.Called(ctx, authentication_, code)
+	return args.Get(0).(authentication.Tokens), args.Error(1)
+}
+
+func (s *serviceMock) LinkAuthentication(ctx context.Context, authentication_ authentication.Authentication, code string, link authentication.PendingLink) (authentication.Tokens, error) {
+	args := s.Called(ctx, authentication_, code, link)
+	return args.Get(0).(authentication.Tokens), args.Error(1)
+}
+
+func (s *serviceMock) Refresh(ctx context.Context, refreshToken string) (authentication.Tokens, error) {
+	args := s.Called(ctx, refreshToken)
+	return args.Get(0).(authentication.Tokens), args.Error(1)
+}
+
+func (s *serviceMock) Logout(token string, refreshToken string) error {
+	args := s.Called(token, refreshToken)
+	return args.Error(0)
 }
 
-func (s *serviceMock) VerifyAuthentication(ctx context.Context, code string) (string, error) {
-	args := s.Called(ctx, code)
+func (s *serviceMock) EndSession(provider string, idToken string, postLogoutRedirectURI string) (string, error) {
+	args := s.Called(provider, idToken, postLogoutRedirectURI)
 	return args.String(0), args.Error(1)
 }
 
-func (s *serviceMock) GetMyInformation(token string) ([]byte, error) {
+func (s *serviceMock) ValidateToken(token string) (jwt.CClaims, error) {
 	args := s.Called(token)
+	return args.Get(0).(jwt.CClaims), args.Error(1)
+}
+
+func (s *serviceMock) GetProfile(claims jwt.CClaims) (authentication.Profile, error) {
+	args := s.Called(claims)
+	return args.Get(0).(authentication.Profile), args.Error(1)
+}
+
+func (s *serviceMock) GetUser(id string) (user.User, error) {
+	args := s.Called(id)
+	return args.Get(0).(user.User), args.Error(1)
+}
+
+func (s *serviceMock) GetUserInfo(claims jwt.CClaims) ([]byte, error) {
+	args := s.Called(claims)
 	return args.Get(0).([]byte), args.Error(1)
 }
 
+func (s *serviceMock) GetConfiguration() ([]byte, error) {
+	args := s.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (s *serviceMock) GetKeySet() ([]byte, error) {
+	args := s.Called()
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (s *serviceMock) CreateClientToken(clientID string, clientSecret string, scope string) (authentication.Tokens, error) {
+	args := s.Called(clientID, clientSecret, scope)
+	return args.Get(0).(authentication.Tokens), args.Error(1)
+}
+
+func (s *serviceMock) Introspect(clientID string, clientSecret string, token string) ([]byte, error) {
+	args := s.Called(clientID, clientSecret, token)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (s *serviceMock) RevokeToken(clientID string, clientSecret string, token string, tokenTypeHint string) error {
+	args := s.Called(clientID, clientSecret, token, tokenTypeHint)
+	return args.Error(0)
+}
+
 type storageMock struct {
 	mock.Mock
 }
@@ -74,6 +141,31 @@ func (ss *storeMock) Save(r *http.Request, w http.ResponseWriter, s *sessions.Se
 	return args.Error(0)
 }
 
+func newPendingLink() authentication.PendingLink {
+	return authentication.PendingLink{
+		Identity: user.Identity{Provider: "github", Subject: "github|1234"},
+		Email:    "user@example.com",
+	}
+}
+
+func newRedirects() *Redirects {
+	return NewRedirects("https://app.example.com/home", []string{"https://app.example.com"})
+}
+
+// withAuthentication returns r as the Authenticate middleware passes it on
+// after validating token.
+func withAuthentication(r *http.Request, token string, claims jwt.CClaims) *http.Request {
+	ctx := context.WithValue(r.Context(), contextKey{}, authenticated{token: token, claims: claims, valid: true})
+	return r.WithContext(ctx)
+}
+
+// withInvalidToken stores token as OptionalAuthenticate does when it is not
+// valid.
+func withInvalidToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), contextKey{}, authenticated{token: token})
+	return r.WithContext(ctx)
+}
+
 func TestHandler_Login(t *testing.T) {
 	// Given
 	w := httptest.NewRecorder()
@@ -81,7 +173,73 @@ func TestHandler_Login(t *testing.T) {
 
 	wrapper := wrapperMock{}
 	service_ := serviceMock{}
-	service_.On("CreateAuthentication").Return("uri", "state", nil)
+	service_.On("CreateAuthentication", "").Return(authentication.Authentication{URL: "uri", Provider: "", State: "state", CodeVerifier: "verifier"}, nil)
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "auth-session")
+	storage.On("Get", r, "auth-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Login()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+}
+
+func TestHandler_Login_DropsPendingLink(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("CreateAuthentication", "").Return(authentication.Authentication{URL: "uri", State: "state"}, nil)
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "auth-session")
+	session.Values["link_provider"] = "github"
+	session.Values["link_subject"] = "github|1234"
+	session.Values["link_email"] = "user@example.com"
+	storage.On("Get", r, "auth-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Login()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	_, linking := pendingLink(session.Values)
+	require.False(t, linking)
+	require.NotContains(t, session.Values, "link_provider")
+	require.NotContains(t, session.Values, "link_subject")
+	require.NotContains(t, session.Values, "link_email")
+}
+
+func TestHandler_Login_WithProvider(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r = mux.SetURLVars(r, map[string]string{"provider": "github"})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("CreateAuthentication", "github").Return(authentication.Authentication{URL: "uri", Provider: "github", State: "state", CodeVerifier: "verifier", Nonce: "nonce"}, nil)
 
 	storage := storageMock{}
 	store := storeMock{}
@@ -90,7 +248,7 @@ func TestHandler_Login(t *testing.T) {
 	storage.On("Get", r, "auth-session").Return(session, nil)
 	store.On("Save", r, w, session).Return(nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 	h.Login()
 
 	// When
@@ -101,6 +259,83 @@ func TestHandler_Login(t *testing.T) {
 
 	// Then
 	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	require.Equal(t, "github", session.Values["provider"])
+	require.Equal(t, "state", session.Values["state"])
+	require.Equal(t, "verifier", session.Values["code_verifier"])
+	require.Equal(t, "nonce", session.Values["nonce"])
+}
+
+func TestHandler_Login_ReturnTo(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares?return_to=https%3A%2F%2Fapp.example.com%2Fcourses", nil)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("CreateAuthentication", "").Return(authentication.Authentication{URL: "uri", State: "state"}, nil)
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "auth-session")
+	storage.On("Get", r, "auth-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Login()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, "https://app.example.com/courses", session.Values["return_to"])
+}
+
+func TestHandler_Login_InvalidReturnToError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares?return_to=https%3A%2F%2Fevil.example.com", nil)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+
+	h := NewHandler(&wrapper, &service_, nil, newRedirects())
+	h.Login()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "400 bad_request: invalid return_to parameter")
+}
+
+func TestHandler_Login_UnsupportedProviderError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r = mux.SetURLVars(r, map[string]string{"provider": "unknown"})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("CreateAuthentication", "unknown").Return(authentication.Authentication{}, authentication.ErrNotFound)
+
+	h := NewHandler(&wrapper, &service_, nil, newRedirects())
+	h.Login()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "404 not_found: authentication: resource not found")
 }
 
 func TestHandler_Login_CreateAuthenticationError(t *testing.T) {
@@ -110,9 +345,9 @@ func TestHandler_Login_CreateAuthenticationError(t *testing.T) {
 
 	wrapper := wrapperMock{}
 	service_ := serviceMock{}
-	service_.On("CreateAuthentication").Return("", "", errors.New("error"))
+	service_.On("CreateAuthentication", "").Return(authentication.Authentication{}, errors.New("error"))
 
-	h := NewHandler(&wrapper, &service_, nil)
+	h := NewHandler(&wrapper, &service_, nil, newRedirects())
 	h.Login()
 
 	// When
@@ -132,12 +367,12 @@ func TestHandler_Login_GetSessionFromStorageError(t *testing.T) {
 
 	wrapper := wrapperMock{}
 	service_ := serviceMock{}
-	service_.On("CreateAuthentication").Return("uri", "state", nil)
+	service_.On("CreateAuthentication", "").Return(authentication.Authentication{URL: "uri", Provider: "", State: "state", CodeVerifier: "verifier"}, nil)
 
 	storage := storageMock{}
 	storage.On("Get", r, "auth-session").Return(&sessions.Session{}, errors.New("error"))
 
-	h := NewHandler(&wrapper, &service_, &storage)
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 	h.Login()
 
 	// When
@@ -157,7 +392,7 @@ func TestHandler_Login_SessionSaveError(t *testing.T) {
 
 	wrapper := wrapperMock{}
 	service_ := serviceMock{}
-	service_.On("CreateAuthentication").Return("uri", "state", nil)
+	service_.On("CreateAuthentication", "").Return(authentication.Authentication{URL: "uri", Provider: "", State: "state", CodeVerifier: "verifier"}, nil)
 
 	storage := storageMock{}
 	store := storeMock{}
@@ -166,7 +401,7 @@ func TestHandler_Login_SessionSaveError(t *testing.T) {
 	storage.On("Get", r, "auth-session").Return(session, nil)
 	store.On("Save", r, w, session).Return(errors.New("error"))
 
-	h := NewHandler(&wrapper, &service_, &storage)
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 	h.Login()
 
 	// When
@@ -199,13 +434,17 @@ func TestHandler_LoginCallback(t *testing.T) {
 	store.On("Save", r, w, session).Return(nil)
 
 	service_ := serviceMock{}
-	service_.On("VerifyAuthentication", ctx, "_code_").Return("token", nil)
+	service_.On("VerifyAuthentication", ctx, authentication.Authentication{State: "_state_"}, "_code_").Return(authentication.Tokens{AccessToken: "token", RefreshToken: "refresh", IDToken: "_id_token_"}, nil)
 
 	wrapper := wrapperMock{}
 	storage := storageMock{}
 	storage.On("Get", r, "auth-session").Return(session, nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
+	logoutSession := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(logoutSession, nil)
+	store.On("Save", r, w, logoutSession).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 	h.LoginCallback()
 
 	// When
@@ -215,11 +454,101 @@ func TestHandler_LoginCallback(t *testing.T) {
 	}
 
 	// Then
-	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://app.example.com/home", w.Header().Get("Location"))
+	require.Equal(t, "_id_token_", logoutSession.Values["id_token"])
 
 	cookies := w.Header().Values("Set-Cookie")
-	require.Len(t, cookies, 1)
+	require.Len(t, cookies, 3)
 	require.Equal(t, cookies[0], "token=token; Path=/; HttpOnly")
+	require.Equal(t, cookies[1], "refresh_token=refresh; Path=/token/refresh; HttpOnly")
+	require.Equal(t, cookies[2], "refresh_token=refresh; Path=/logout; HttpOnly")
+}
+
+func TestHandler_LoginCallback_AuthenticationFromSession(t *testing.T) {
+	// Given
+	store := storeMock{}
+	session := sessions.NewSession(&store, "auth-session")
+	session.Values["state"] = "_state_"
+	session.Values["provider"] = "github"
+	session.Values["code_verifier"] = "_verifier_"
+	session.Values["nonce"] = "_nonce_"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	q := r.URL.Query()
+
+	q.Add("state", "_state_")
+	q.Add("code", "_code_")
+	r.URL.RawQuery = q.Encode()
+
+	ctx := context.Background()
+	r = r.WithContext(ctx)
+
+	store.On("Save", r, w, session).Return(nil)
+
+	service_ := serviceMock{}
+	service_.On("VerifyAuthentication", ctx, authentication.Authentication{Provider: "github", State: "_state_", CodeVerifier: "_verifier_", Nonce: "_nonce_"}, "_code_").Return(authentication.Tokens{AccessToken: "token", RefreshToken: "refresh"}, nil)
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	storage.On("Get", r, "auth-session").Return(session, nil)
+
+	logoutSession := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(logoutSession, nil)
+	store.On("Save", r, w, logoutSession).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.LoginCallback()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	service_.AssertExpectations(t)
+}
+
+func TestHandler_LoginCallback_RedirectsToReturnTo(t *testing.T) {
+	// Given
+	store := storeMock{}
+	session := sessions.NewSession(&store, "auth-session")
+	session.Values["state"] = "_state_"
+	session.Values["return_to"] = "https://app.example.com/courses"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares?state=_state_&code=_code_", nil)
+
+	ctx := context.Background()
+	r = r.WithContext(ctx)
+
+	store.On("Save", r, w, session).Return(nil)
+
+	service_ := serviceMock{}
+	service_.On("VerifyAuthentication", ctx, authentication.Authentication{State: "_state_"}, "_code_").Return(authentication.Tokens{AccessToken: "token", RefreshToken: "refresh"}, nil)
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	storage.On("Get", r, "auth-session").Return(session, nil)
+
+	logoutSession := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(logoutSession, nil)
+	store.On("Save", r, w, logoutSession).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.LoginCallback()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://app.example.com/courses", w.Header().Get("Location"))
 }
 
 func TestHandler_LoginCallback_GetSessionFromStorageError(t *testing.T) {
@@ -232,7 +561,7 @@ func TestHandler_LoginCallback_GetSessionFromStorageError(t *testing.T) {
 	storage := storageMock{}
 	storage.On("Get", r, "auth-session").Return(&sessions.Session{}, errors.New("error"))
 
-	h := NewHandler(&wrapper, &service_, &storage)
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 	h.LoginCallback()
 
 	// When
@@ -263,7 +592,7 @@ func TestHandler_LoginCallback_InvalidStateParameter(t *testing.T) {
 	storage := storageMock{}
 	storage.On("Get", r, "auth-session").Return(session, nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 	h.LoginCallback()
 
 	// When
@@ -299,7 +628,7 @@ func TestHandler_LoginCallback_SessionSaveError(t *testing.T) {
 	storage := storageMock{}
 	storage.On("Get", r, "auth-session").Return(session, nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 	h.LoginCallback()
 
 	// When
@@ -336,7 +665,7 @@ func TestHandler_LoginCallback_CodeIsMissing(t *testing.T) {
 	storage := storageMock{}
 	storage.On("Get", r, "auth-session").Return(session, nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 	h.LoginCallback()
 
 	// When
@@ -393,13 +722,13 @@ func TestHandler_LoginCallback_VerifyAuthenticationError(t *testing.T) {
 			store.On("Save", r, w, session).Return(nil)
 
 			service_ := serviceMock{}
-			service_.On("VerifyAuthentication", ctx, "_code_").Return("", tc.returnedError)
+			service_.On("VerifyAuthentication", ctx, authentication.Authentication{State: "_state_"}, "_code_").Return(authentication.Tokens{}, tc.returnedError)
 
 			wrapper := wrapperMock{}
 			storage := storageMock{}
 			storage.On("Get", r, "auth-session").Return(session, nil)
 
-			h := NewHandler(&wrapper, &service_, &storage)
+			h := NewHandler(&wrapper, &service_, &storage, newRedirects())
 			h.LoginCallback()
 
 			// When
@@ -414,44 +743,31 @@ func TestHandler_LoginCallback_VerifyAuthenticationError(t *testing.T) {
 	}
 }
 
-func TestHandler_Logout(t *testing.T) {
+func TestHandler_LoginCallback_ForbiddenError(t *testing.T) {
 	// Given
-	w := httptest.NewRecorder()
-	r, _ := http.NewRequest("GET", "whocares", nil)
-	r.AddCookie(&http.Cookie{Name: "token", Value: "_token_"})
-
-	wrapper := wrapperMock{}
-	service_ := serviceMock{}
-	storage := storageMock{}
+	store := storeMock{}
+	session := sessions.NewSession(&store, "auth-session")
+	session.Values["state"] = "_state_"
 
-	h := NewHandler(&wrapper, &service_, &storage)
-	h.Logout()
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares?state=_state_&code=_code_", nil)
 
-	// When
-	err := wrapper.f(w, r)
-	if err != nil {
-		t.Fatal(err)
-	}
+	ctx := context.Background()
+	r = r.WithContext(ctx)
 
-	// Then
-	require.Equal(t, http.StatusOK, w.Code)
+	store.On("Save", r, w, session).Return(nil)
 
-	cookies := w.Header().Values("Set-Cookie")
-	require.Len(t, cookies, 1)
-	require.Equal(t, "token=_token_; Max-Age=0", cookies[0])
-}
+	forbiddenErr := fmt.Errorf("could not verify email: %w", &authentication.ForbiddenError{Reason: "email_domain_not_allowed"})
 
-func TestHandler_Logout_TokenCookieNotFound(t *testing.T) {
-	// Given
-	w := httptest.NewRecorder()
-	r, _ := http.NewRequest("GET", "whocares", nil)
+	service_ := serviceMock{}
+	service_.On("VerifyAuthentication", ctx, authentication.Authentication{State: "_state_"}, "_code_").Return(authentication.Tokens{}, forbiddenErr)
 
 	wrapper := wrapperMock{}
-	service_ := serviceMock{}
 	storage := storageMock{}
+	storage.On("Get", r, "auth-session").Return(session, nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
-	h.Logout()
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.LoginCallback()
 
 	// When
 	err := wrapper.f(w, r)
@@ -460,22 +776,136 @@ func TestHandler_Logout_TokenCookieNotFound(t *testing.T) {
 	}
 
 	// Then
-	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.JSONEq(t, `{"status":403,"code":"forbidden","message":"could not verify email: sign in is not allowed: email_domain_not_allowed","reason":"email_domain_not_allowed"}`, w.Body.String())
+	require.Empty(t, w.Header().Values("Set-Cookie"))
 }
 
-func TestHandler_Me(t *testing.T) {
+func TestHandler_LoginCallback_LinkRequired(t *testing.T) {
 	// Given
-	w := httptest.NewRecorder()
-	r, _ := http.NewRequest("GET", "whocares", nil)
-	r.Header.Add("Authorization", "Bearer _token_")
+	store := storeMock{}
+	session := sessions.NewSession(&store, "auth-session")
+	session.Values["state"] = "_state_"
+	session.Values["provider"] = "github"
+	session.Values["return_to"] = "https://app.example.com/courses"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares?state=_state_&code=_code_", nil)
+
+	ctx := context.Background()
+	r = r.WithContext(ctx)
+
+	store.On("Save", r, w, session).Return(nil)
+
+	linkErr := &authentication.LinkRequiredError{Provider: "google", Link: newPendingLink()}
+
+	service_ := serviceMock{}
+	service_.On("VerifyAuthentication", ctx, authentication.Authentication{Provider: "github", State: "_state_"}, "_code_").Return(authentication.Tokens{}, linkErr)
 
 	wrapper := wrapperMock{}
 	storage := storageMock{}
+	storage.On("Get", r, "auth-session").Return(session, nil)
+
+	linkSession := sessions.NewSession(&store, "link-session")
+	storage.On("Get", r, "link-session").Return(linkSession, nil)
+	store.On("Save", r, w, linkSession).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.LoginCallback()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.JSONEq(t, `{"status":409,"code":"link_required","message":"`+linkErr.Error()+`","provider":"google","link_url":"/link"}`, w.Body.String())
+	require.Equal(t, map[interface{}]interface{}{
+		"provider":      "google",
+		"link_provider": "github",
+		"link_subject":  "github|1234",
+		"link_email":    "user@example.com",
+		"return_to":     "https://app.example.com/courses",
+	}, linkSession.Values)
+	require.Empty(t, w.Header().Values("Set-Cookie"))
+}
+
+func TestHandler_LoginCallback_Link(t *testing.T) {
+	// Given
+	store := storeMock{}
+	session := sessions.NewSession(&store, "auth-session")
+	session.Values["state"] = "_state_"
+	session.Values["provider"] = "google"
+	session.Values["link_provider"] = "github"
+	session.Values["link_subject"] = "github|1234"
+	session.Values["link_email"] = "user@example.com"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares?state=_state_&code=_code_", nil)
+
+	ctx := context.Background()
+	r = r.WithContext(ctx)
+
+	store.On("Save", r, w, session).Return(nil)
+
 	service_ := serviceMock{}
-	service_.On("GetMyInformation", "Bearer _token_").Return([]byte(`{"name":"example"}`), nil)
+	service_.On("LinkAuthentication", ctx, authentication.Authentication{Provider: "google", State: "_state_"}, "_code_", newPendingLink()).Return(authentication.Tokens{AccessToken: "token", RefreshToken: "refresh"}, nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
-	h.Me()
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	storage.On("Get", r, "auth-session").Return(session, nil)
+
+	logoutSession := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(logoutSession, nil)
+	store.On("Save", r, w, logoutSession).Return(nil)
+
+	linkSession := sessions.NewSession(&store, "link-session")
+	storage.On("Get", r, "link-session").Return(linkSession, nil)
+	store.On("Save", r, w, linkSession).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.LoginCallback()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, -1, linkSession.Options.MaxAge)
+	service_.AssertExpectations(t)
+}
+
+func TestHandler_Link(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("CreateAuthentication", "google").Return(authentication.Authentication{URL: "uri", Provider: "google", State: "state"}, nil)
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	linkSession := sessions.NewSession(&store, "link-session")
+	linkSession.Values["provider"] = "google"
+	linkSession.Values["link_provider"] = "github"
+	linkSession.Values["link_subject"] = "github|1234"
+	linkSession.Values["link_email"] = "user@example.com"
+	linkSession.Values["return_to"] = "https://app.example.com/courses"
+	storage.On("Get", r, "link-session").Return(linkSession, nil)
+
+	session := sessions.NewSession(&store, "auth-session")
+	storage.On("Get", r, "auth-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Link()
 
 	// When
 	err := wrapper.f(w, r)
@@ -484,31 +914,103 @@ func TestHandler_Me(t *testing.T) {
 	}
 
 	// Then
-	var body struct {
-		Name string `json:"name"`
+	require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	require.Equal(t, "uri", w.Header().Get("Location"))
+	require.Equal(t, "github", session.Values["link_provider"])
+	require.Equal(t, "github|1234", session.Values["link_subject"])
+	require.Equal(t, "user@example.com", session.Values["link_email"])
+	require.Equal(t, "https://app.example.com/courses", session.Values["return_to"])
+}
+
+func TestHandler_Link_NoIdentityError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	storage.On("Get", r, "link-session").Return(sessions.NewSession(&storeMock{}, "link-session"), nil)
+
+	h := NewHandler(&wrapper, &serviceMock{}, &storage, newRedirects())
+	h.Link()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "400 bad_request: no identity to link")
+}
+
+func TestHandler_RefreshToken(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", strings.NewReader("refresh_token=_refresh_"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	service_ := serviceMock{}
+	service_.On("Refresh", mock.Anything, "_refresh_").Return(authentication.Tokens{AccessToken: "token", RefreshToken: "refresh", TokenType: "Bearer"}, nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.RefreshToken()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
 	}
 
+	// Then
+	var body authentication.Tokens
 	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
 		t.Fatal(err)
 	}
 
 	require.Equal(t, http.StatusOK, w.Code)
-	require.Equal(t, "example", body.Name)
+	require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	require.Equal(t, authentication.Tokens{AccessToken: "token", RefreshToken: "refresh", TokenType: "Bearer"}, body)
+	require.Len(t, w.Header().Values("Set-Cookie"), 3)
 }
 
-func TestHandler_Me_GetMyInformationParsingTokenError(t *testing.T) {
+func TestHandler_RefreshToken_FromCookie(t *testing.T) {
 	// Given
 	w := httptest.NewRecorder()
-	r, _ := http.NewRequest("GET", "whocares", nil)
-	r.Header.Add("Authorization", "Bearer _token_")
+	r, _ := http.NewRequest("POST", "whocares", nil)
+	r.AddCookie(&http.Cookie{Name: "refresh_token", Value: "_refresh_"})
 
 	wrapper := wrapperMock{}
 	storage := storageMock{}
 	service_ := serviceMock{}
-	service_.On("GetMyInformation", "Bearer _token_").Return([]byte{}, authentication.ErrParse)
+	service_.On("Refresh", mock.Anything, "_refresh_").Return(authentication.Tokens{AccessToken: "token", RefreshToken: "refresh"}, nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
-	h.Me()
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.RefreshToken()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandler_RefreshToken_MissingRefreshToken(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	service_ := serviceMock{}
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.RefreshToken()
 
 	// When
 	err := wrapper.f(w, r)
@@ -517,29 +1019,1062 @@ func TestHandler_Me_GetMyInformationParsingTokenError(t *testing.T) {
 	}
 
 	// Then
-	require.EqualError(t, err, "403 forbidden: authentication: could not parse resource")
+	require.EqualError(t, err, "400 bad_request: invalid refresh_token parameter")
+}
+
+func TestHandler_RefreshToken_RefreshError(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "error",
+		},
+		{
+			name:          "verification error",
+			returnedError: authentication.ErrVerification,
+			expectedError: "401 unauthorized: authentication: could not verify resource",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", "whocares", strings.NewReader("refresh_token=_refresh_"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			wrapper := wrapperMock{}
+			storage := storageMock{}
+			service_ := serviceMock{}
+			service_.On("Refresh", mock.Anything, "_refresh_").Return(authentication.Tokens{}, tc.returnedError)
+
+			h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+			h.RefreshToken()
+
+			// When
+			err := wrapper.f(w, r)
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestHandler_RefreshToken_ForbiddenError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", strings.NewReader("refresh_token=_refresh_"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	forbiddenErr := fmt.Errorf("could not run hooks: %w", &authentication.ForbiddenError{Reason: "user_suspended"})
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	service_ := serviceMock{}
+	service_.On("Refresh", mock.Anything, "_refresh_").Return(authentication.Tokens{}, forbiddenErr)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.RefreshToken()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.JSONEq(t, `{"status":403,"code":"forbidden","message":"could not run hooks: sign in is not allowed: user_suspended","reason":"user_suspended"}`, w.Body.String())
+	require.Empty(t, w.Header().Values("Set-Cookie"))
+}
+
+func TestHandler_Logout(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: "_token_"})
+	r = withAuthentication(r, "_token_", jwt.CClaims{})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("Logout", "_token_", "").Return(nil)
+	service_.On("EndSession", "google", "_id_token_", "https://app.example.com/home").Return("https://issuer.example.com/logout?id_token_hint=_id_token_", nil)
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "logout-session")
+	session.Values["provider"] = "google"
+	session.Values["id_token"] = "_id_token_"
+	storage.On("Get", r, "logout-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Logout()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://issuer.example.com/logout?id_token_hint=_id_token_", w.Header().Get("Location"))
+	require.Equal(t, -1, session.Options.MaxAge)
+
+	requireExpiredTokenCookies(t, w.Header().Values("Set-Cookie"))
 }
 
-func TestHandler_Me_GetMyInformationError(t *testing.T) {
+func TestHandler_Logout_WithRefreshToken(t *testing.T) {
 	// Given
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest("GET", "whocares", nil)
-	r.Header.Add("Authorization", "Bearer _token_")
+	r.AddCookie(&http.Cookie{Name: "token", Value: "_token_"})
+	r.AddCookie(&http.Cookie{Name: "refresh_token", Value: "_refresh_"})
+	r = withAuthentication(r, "_token_", jwt.CClaims{})
 
 	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("Logout", "_token_", "_refresh_").Return(nil)
+	service_.On("EndSession", "", "", "https://app.example.com/home").Return("", nil)
+
 	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Logout()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	requireExpiredTokenCookies(t, w.Header().Values("Set-Cookie"))
+}
+
+func TestHandler_Logout_InvalidToken(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: "_token_"})
+	r.AddCookie(&http.Cookie{Name: "refresh_token", Value: "_refresh_"})
+	r = withInvalidToken(r, "_token_")
+
+	wrapper := wrapperMock{}
 	service_ := serviceMock{}
-	service_.On("GetMyInformation", "Bearer _token_").Return([]byte{}, errors.New("error"))
+	service_.On("Logout", "_token_", "_refresh_").Return(nil)
+	service_.On("EndSession", "google", "_id_token_", "https://app.example.com/home").Return("https://issuer.example.com/logout?id_token_hint=_id_token_", nil)
 
-	h := NewHandler(&wrapper, &service_, &storage)
-	h.Me()
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "logout-session")
+	session.Values["provider"] = "google"
+	session.Values["id_token"] = "_id_token_"
+	storage.On("Get", r, "logout-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Logout()
 
 	// When
 	err := wrapper.f(w, r)
-	if err == nil {
-		t.Fatal("test must fail")
+	if err != nil {
+		t.Fatal(err)
 	}
 
 	// Then
-	require.EqualError(t, err, "error")
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://issuer.example.com/logout?id_token_hint=_id_token_", w.Header().Get("Location"))
+
+	requireExpiredTokenCookies(t, w.Header().Values("Set-Cookie"))
+	service_.AssertExpectations(t)
+}
+
+func TestHandler_Logout_RefreshTokenOnly(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r.AddCookie(&http.Cookie{Name: "refresh_token", Value: "_refresh_"})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("Logout", "", "_refresh_").Return(nil)
+	service_.On("EndSession", "", "", "https://app.example.com/home").Return("", nil)
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Logout()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	requireExpiredTokenCookies(t, w.Header().Values("Set-Cookie"))
+}
+
+func TestHandler_Logout_RefreshTokenFormValue(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", strings.NewReader("refresh_token=_refresh_"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = withAuthentication(r, "_token_", jwt.CClaims{})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("Logout", "_token_", "_refresh_").Return(nil)
+	service_.On("EndSession", "", "", "https://app.example.com/home").Return("", nil)
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Logout()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	service_.AssertExpectations(t)
+	requireExpiredTokenCookies(t, w.Header().Values("Set-Cookie"))
+}
+
+// requireExpiredTokenCookies asserts that cookies expire the token cookies on
+// the paths setTokenCookies sets them on.
+func requireExpiredTokenCookies(t *testing.T, cookies []string) {
+	t.Helper()
+
+	require.Equal(t, []string{
+		"token=; Path=/; Max-Age=0; HttpOnly",
+		"refresh_token=; Path=/token/refresh; Max-Age=0; HttpOnly",
+		"refresh_token=; Path=/logout; Max-Age=0; HttpOnly",
+	}, cookies)
+}
+
+func TestSetTokenCookies_RefreshTokenSentOnLogout(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://auth.example.com/login/callback")
+
+	// When
+	setTokenCookies(w, authentication.Tokens{AccessToken: "_token_", RefreshToken: "_refresh_"})
+	jar.SetCookies(u, w.Result().Cookies())
+
+	// Then
+	for _, path := range []string{"/token/refresh", "/logout"} {
+		u, _ := url.Parse("https://auth.example.com" + path)
+
+		var refreshToken string
+		for _, c := range jar.Cookies(u) {
+			if c.Name == "refresh_token" {
+				refreshToken = c.Value
+			}
+		}
+
+		require.Equal(t, "_refresh_", refreshToken, path)
+	}
+}
+
+func TestHandler_Logout_ReturnTo(t *testing.T) {
+	tt := []struct {
+		name                  string
+		returnTo              string
+		postLogoutRedirectURI string
+	}{
+		{
+			name:                  "allowed origin",
+			returnTo:              "https://app.example.com/bye",
+			postLogoutRedirectURI: "https://app.example.com/bye",
+		},
+		{
+			name:                  "local path",
+			returnTo:              "/bye",
+			postLogoutRedirectURI: "https://app.example.com/bye",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "whocares?return_to="+url.QueryEscape(tc.returnTo), nil)
+
+			wrapper := wrapperMock{}
+			service_ := serviceMock{}
+			service_.On("EndSession", "", "", tc.postLogoutRedirectURI).Return("", nil)
+
+			storage := storageMock{}
+			store := storeMock{}
+
+			session := sessions.NewSession(&store, "logout-session")
+			storage.On("Get", r, "logout-session").Return(session, nil)
+			store.On("Save", r, w, session).Return(nil)
+
+			h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+			h.Logout()
+
+			// When
+			err := wrapper.f(w, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.Equal(t, http.StatusFound, w.Code)
+			require.Equal(t, tc.postLogoutRedirectURI, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestHandler_Logout_InvalidReturnToError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares?return_to=https%3A%2F%2Fevil.example.com", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: "_token_"})
+	r = withAuthentication(r, "_token_", jwt.CClaims{})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+
+	h := NewHandler(&wrapper, &service_, nil, newRedirects())
+	h.Logout()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "400 bad_request: invalid return_to parameter")
+	service_.AssertNotCalled(t, "Logout", "_token_", "")
+}
+
+func TestHandler_Logout_LogoutError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: "_token_"})
+	r = withAuthentication(r, "_token_", jwt.CClaims{})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("Logout", "_token_", "").Return(errors.New("error"))
+
+	storage := storageMock{}
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Logout()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "error")
+}
+
+func TestHandler_Logout_EndSessionError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("EndSession", "", "", "https://app.example.com/home").Return("", errors.New("error"))
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Logout()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "error")
+}
+
+func TestHandler_Logout_TokenCookieNotFound(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("EndSession", "", "", "https://app.example.com/home").Return("https://issuer.example.com/v2/logout", nil)
+
+	storage := storageMock{}
+	store := storeMock{}
+
+	session := sessions.NewSession(&store, "logout-session")
+	storage.On("Get", r, "logout-session").Return(session, nil)
+	store.On("Save", r, w, session).Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Logout()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusFound, w.Code)
+	require.Equal(t, "https://issuer.example.com/v2/logout", w.Header().Get("Location"))
+	require.Empty(t, w.Header().Values("Set-Cookie"))
+}
+
+func TestHandler_Me(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	claims := jwt.CClaims{Metadata: &jwt.MetaData{Name: "example"}}
+	r = withAuthentication(r, "_token_", claims)
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	service_ := serviceMock{}
+	service_.On("GetProfile", claims).Return(authentication.Profile{
+		Subject:     "_sub_",
+		Metadata:    &jwt.MetaData{Name: "example"},
+		Roles:       []string{},
+		Permissions: []string{},
+	}, nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Me()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"sub":"_sub_","metadata":{"name":"example","email":"","avatar_url":""},"roles":[],"permissions":[],"iat":0,"exp":0}`, w.Body.String())
+	service_.AssertNotCalled(t, "ValidateToken", mock.Anything)
+}
+
+func TestHandler_Me_GetProfileError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r = withAuthentication(r, "_token_", jwt.CClaims{})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("GetProfile", jwt.CClaims{}).Return(authentication.Profile{}, errors.New("error"))
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.Me()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "error")
+}
+
+func TestHandler_Me_NotAuthenticatedError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	service_ := serviceMock{}
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.Me()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "401 unauthorized: missing token")
+}
+
+func TestHandler_User(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": "google-oauth2|1234"})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("GetUser", "google-oauth2|1234").Return(user.User{ID: "google-oauth2|1234", Name: "example"}, nil)
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.User()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	var body user.User
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "google-oauth2|1234", body.ID)
+	require.Equal(t, "example", body.Name)
+}
+
+func TestHandler_User_GetUserErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "not found error",
+			returnedError: authentication.ErrNotFound,
+			expectedError: "404 not_found: authentication: resource not found",
+		},
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "error",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "whocares", nil)
+			r = mux.SetURLVars(r, map[string]string{"id": "_id_"})
+
+			wrapper := wrapperMock{}
+			service_ := serviceMock{}
+			service_.On("GetUser", "_id_").Return(user.User{}, tc.returnedError)
+
+			h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+			h.User()
+
+			// When
+			err := wrapper.f(w, r)
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestHandler_UserInfo(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	claims := jwt.CClaims{Metadata: &jwt.MetaData{Name: "example"}}
+	r = withAuthentication(r, "_token_", claims)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("GetUserInfo", claims).Return([]byte(`{"sub":"_sub_","name":"example"}`), nil)
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.UserInfo()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"sub":"_sub_","name":"example"}`, w.Body.String())
+}
+
+func TestHandler_UserInfo_NotAuthenticatedError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.UserInfo()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "401 unauthorized: missing token")
+	require.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	service_.AssertNotCalled(t, "GetUserInfo", mock.Anything)
+}
+
+func TestHandler_UserInfo_GetUserInfoError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r = withAuthentication(r, "_token_", jwt.CClaims{})
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("GetUserInfo", jwt.CClaims{}).Return([]byte{}, errors.New("error"))
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.UserInfo()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "error")
+}
+
+func TestHandler_AuthorizationServerMetadata(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("GetConfiguration").Return([]byte(`{"issuer":"https://auth.example.com"}`), nil)
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.AuthorizationServerMetadata()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"issuer":"https://auth.example.com"}`, w.Body.String())
+}
+
+func TestHandler_JWKS(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	service_ := serviceMock{}
+	service_.On("GetKeySet").Return([]byte(`{"keys":[]}`), nil)
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.JWKS()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"keys":[]}`, w.Body.String())
+}
+
+func TestHandler_JWKS_GetKeySetError(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+
+	wrapper := wrapperMock{}
+	storage := storageMock{}
+	service_ := serviceMock{}
+	service_.On("GetKeySet").Return([]byte{}, errors.New("error"))
+
+	h := NewHandler(&wrapper, &service_, &storage, newRedirects())
+	h.JWKS()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "error")
+}
+
+func TestHandler_Token(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", strings.NewReader("grant_type=client_credentials&scope=courses%3Aread"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("_client_", "_secret_")
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("CreateClientToken", "_client_", "_secret_", "courses:read").Return(authentication.Tokens{AccessToken: "token", TokenType: "Bearer", Scope: "courses:read"}, nil)
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.Token()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	require.JSONEq(t, `{"access_token":"token","token_type":"Bearer","scope":"courses:read"}`, w.Body.String())
+}
+
+func TestHandler_Token_UnsupportedGrantType(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", strings.NewReader("grant_type=password"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.Token()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "400 bad_request: unsupported grant_type parameter")
+}
+
+func TestHandler_Token_CreateClientTokenErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "error",
+		},
+		{
+			name:          "invalid client error",
+			returnedError: authentication.ErrVerification,
+			expectedError: "401 unauthorized: authentication: could not verify resource",
+		},
+		{
+			name:          "invalid scope error",
+			returnedError: authentication.ErrScope,
+			expectedError: "400 bad_request: authentication: scope is not allowed",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", "whocares", strings.NewReader("grant_type=client_credentials"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("_client_", "_secret_")
+
+			wrapper := wrapperMock{}
+			service_ := serviceMock{}
+			service_.On("CreateClientToken", "_client_", "_secret_", "").Return(authentication.Tokens{}, tc.returnedError)
+
+			h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+			h.Token()
+
+			// When
+			err := wrapper.f(w, r)
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestHandler_Introspect(t *testing.T) {
+	tt := []struct {
+		name    string
+		request func() *http.Request
+	}{
+		{
+			name: "basic authentication",
+			request: func() *http.Request {
+				r, _ := http.NewRequest("POST", "whocares", strings.NewReader("token=_token_"))
+				r.SetBasicAuth("_client_", "_sec%2Fret_")
+				return r
+			},
+		},
+		{
+			name: "body authentication",
+			request: func() *http.Request {
+				r, _ := http.NewRequest("POST", "whocares", strings.NewReader("token=_token_&client_id=_client_&client_secret=_sec%2Fret_"))
+				return r
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r := tc.request()
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			wrapper := wrapperMock{}
+			service_ := serviceMock{}
+			service_.On("Introspect", "_client_", "_sec/ret_", "_token_").Return([]byte(`{"active":true,"sub":"_sub_"}`), nil)
+
+			h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+			h.Introspect()
+
+			// When
+			err := wrapper.f(w, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Then
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			require.JSONEq(t, `{"active":true,"sub":"_sub_"}`, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_Introspect_TokenIsMissing(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", nil)
+	r.SetBasicAuth("_client_", "_secret_")
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.Introspect()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "400 bad_request: invalid token parameter")
+}
+
+func TestHandler_Introspect_IntrospectErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "error",
+		},
+		{
+			name:          "invalid client error",
+			returnedError: authentication.ErrVerification,
+			expectedError: "401 unauthorized: authentication: could not verify resource",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", "whocares", strings.NewReader("token=_token_"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("_client_", "_secret_")
+
+			wrapper := wrapperMock{}
+			service_ := serviceMock{}
+			service_.On("Introspect", "_client_", "_secret_", "_token_").Return([]byte{}, tc.returnedError)
+
+			h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+			h.Introspect()
+
+			// When
+			err := wrapper.f(w, r)
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestHandler_Revoke(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", strings.NewReader("token=_token_&token_type_hint=refresh_token"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("_client_", "_secret_")
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+	service_.On("RevokeToken", "_client_", "_secret_", "_token_", "refresh_token").Return(nil)
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.Revoke()
+
+	// When
+	err := wrapper.f(w, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Body.String())
+	service_.AssertExpectations(t)
+}
+
+func TestHandler_Revoke_TokenIsMissing(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "whocares", nil)
+	r.SetBasicAuth("_client_", "_secret_")
+
+	wrapper := wrapperMock{}
+	service_ := serviceMock{}
+
+	h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+	h.Revoke()
+
+	// When
+	err := wrapper.f(w, r)
+	if err == nil {
+		t.Fatal("test must fail")
+	}
+
+	// Then
+	require.EqualError(t, err, "400 bad_request: invalid token parameter")
+}
+
+func TestHandler_Revoke_RevokeTokenErrors(t *testing.T) {
+	tt := []struct {
+		name          string
+		returnedError error
+		expectedError string
+	}{
+		{
+			name:          "generic error",
+			returnedError: errors.New("error"),
+			expectedError: "error",
+		},
+		{
+			name:          "invalid client error",
+			returnedError: authentication.ErrVerification,
+			expectedError: "401 unauthorized: authentication: could not verify resource",
+		},
+		{
+			name:          "not owner error",
+			returnedError: fmt.Errorf("could not revoke token: %w", authentication.ErrNotOwner),
+			expectedError: "403 forbidden: could not revoke token: authentication: token belongs to another party",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", "whocares", strings.NewReader("token=_token_"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("_client_", "_secret_")
+
+			wrapper := wrapperMock{}
+			service_ := serviceMock{}
+			service_.On("RevokeToken", "_client_", "_secret_", "_token_", "").Return(tc.returnedError)
+
+			h := NewHandler(&wrapper, &service_, &storageMock{}, newRedirects())
+			h.Revoke()
+
+			// When
+			err := wrapper.f(w, r)
+			if err == nil {
+				t.Fatal("test must fail")
+			}
+
+			// Then
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
 }
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/jwt"
+	"github.com/mateoferrari97/Kit/web/server"
+)
+
+type TokenValidator interface {
+	ValidateToken(token string) (jwt.CClaims, error)
+}
+
+type contextKey struct{}
+
+// authenticated is what Authenticate and OptionalAuthenticate store in the
+// request context. valid tells whether token passed validation, in which case
+// claims are its claims.
+type authenticated struct {
+	token  string
+	claims jwt.CClaims
+	valid  bool
+}
+
+// Authenticate only lets through requests carrying a valid token, taken from
+// the Authorization header or from the token cookie set on login. The claims
+// of the token are available to the next handlers through ClaimsFromContext.
+func Authenticate(validator TokenValidator) server.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r)
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				respondError(w, server.NewError("missing token", http.StatusUnauthorized))
+				return
+			}
+
+			claims, err := validator.ValidateToken(token)
+			if err != nil {
+				if errors.Is(err, authentication.ErrVerification) || errors.Is(err, authentication.ErrRevoked) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					respondError(w, server.NewError(err.Error(), http.StatusUnauthorized))
+					return
+				}
+
+				respondError(w, server.NewError(err.Error(), http.StatusInternalServerError))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), contextKey{}, authenticated{token: token, claims: claims, valid: true})
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// OptionalAuthenticate lets through every request, like on logout, where the
+// token may already be expired or revoked. Its token is available to the next
+// handlers through tokenFromContext even when it is not valid, but its claims
+// are only available through ClaimsFromContext when it is.
+func OptionalAuthenticate(validator TokenValidator) server.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r)
+			if token == "" {
+				next(w, r)
+				return
+			}
+
+			a := authenticated{token: token}
+
+			claims, err := validator.ValidateToken(token)
+			if err != nil && !errors.Is(err, authentication.ErrVerification) && !errors.Is(err, authentication.ErrRevoked) {
+				respondError(w, server.NewError(err.Error(), http.StatusInternalServerError))
+				return
+			}
+
+			if err == nil {
+				a.claims = claims
+				a.valid = true
+			}
+
+			next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, a)))
+		}
+	}
+}
+
+// RequireScope only lets through requests whose token grants every scope in
+// scopes. It must run after Authenticate, e.g.
+// handler.Me(internal.Authenticate(service), internal.RequireScope("profile:read")).
+func RequireScope(scopes ...string) server.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				respondError(w, server.NewError("missing token", http.StatusUnauthorized))
+				return
+			}
+
+			if !claims.HasScopes(scopes...) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+				respondError(w, server.NewError("insufficient scope", http.StatusForbidden))
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+func ClaimsFromContext(ctx context.Context) (jwt.CClaims, bool) {
+	a, ok := ctx.Value(contextKey{}).(authenticated)
+	return a.claims, ok && a.valid
+}
+
+func tokenFromContext(ctx context.Context) (string, bool) {
+	a, ok := ctx.Value(contextKey{}).(authenticated)
+	return a.token, ok
+}
+
+// bearerToken returns the token of the Authorization header, or of the token
+// cookie set on login for browsers.
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		sToken := strings.Split(header, " ")
+		if len(sToken) != 2 || !strings.EqualFold(sToken[0], "Bearer") {
+			return ""
+		}
+
+		return sToken[1]
+	}
+
+	if c, err := r.Cookie("token"); err == nil {
+		return c.Value
+	}
+
+	return ""
+}
+
+func respondError(w http.ResponseWriter, err *server.Error) {
+	_ = server.RespondJSON(w, err, err.StatusCode)
+}
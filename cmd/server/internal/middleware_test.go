@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/jwt"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type validatorMock struct {
+	mock.Mock
+}
+
+func (v *validatorMock) ValidateToken(token string) (jwt.CClaims, error) {
+	args := v.Called(token)
+	return args.Get(0).(jwt.CClaims), args.Error(1)
+}
+
+func TestAuthenticate(t *testing.T) {
+	tt := []struct {
+		name    string
+		request func() *http.Request
+	}{
+		{
+			name: "authorization header",
+			request: func() *http.Request {
+				r, _ := http.NewRequest("GET", "whocares", nil)
+				r.Header.Set("Authorization", "Bearer _token_")
+				return r
+			},
+		},
+		{
+			name: "token cookie",
+			request: func() *http.Request {
+				r, _ := http.NewRequest("GET", "whocares", nil)
+				r.AddCookie(&http.Cookie{Name: "token", Value: "_token_"})
+				return r
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r := tc.request()
+
+			claims := jwt.CClaims{Roles: []string{"admin"}}
+
+			validator := validatorMock{}
+			validator.On("ValidateToken", "_token_").Return(claims, nil)
+
+			var (
+				token  string
+				called bool
+				result jwt.CClaims
+			)
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				result, _ = ClaimsFromContext(r.Context())
+				token, _ = tokenFromContext(r.Context())
+			}
+
+			// When
+			Authenticate(&validator)(next)(w, r)
+
+			// Then
+			require.True(t, called)
+			require.Equal(t, claims, result)
+			require.Equal(t, "_token_", token)
+		})
+	}
+}
+
+func TestAuthenticate_Errors(t *testing.T) {
+	tt := []struct {
+		name              string
+		authorization     string
+		returnedError     error
+		expectedStatus    int
+		expectedChallenge string
+	}{
+		{
+			name:              "missing token",
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: "Bearer",
+		},
+		{
+			name:              "invalid authorization scheme",
+			authorization:     "Basic _token_",
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: "Bearer",
+		},
+		{
+			name:              "invalid token",
+			authorization:     "Bearer _token_",
+			returnedError:     authentication.ErrVerification,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `Bearer error="invalid_token"`,
+		},
+		{
+			name:              "revoked token",
+			authorization:     "Bearer _token_",
+			returnedError:     authentication.ErrRevoked,
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: `Bearer error="invalid_token"`,
+		},
+		{
+			name:           "generic error",
+			authorization:  "Bearer _token_",
+			returnedError:  errors.New("error"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "whocares", nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+
+			validator := validatorMock{}
+			validator.On("ValidateToken", "_token_").Return(jwt.CClaims{}, tc.returnedError)
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("next must not be called")
+			}
+
+			// When
+			Authenticate(&validator)(next)(w, r)
+
+			// Then
+			require.Equal(t, tc.expectedStatus, w.Code)
+			require.Equal(t, tc.expectedChallenge, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestOptionalAuthenticate(t *testing.T) {
+	tt := []struct {
+		name           string
+		authorization  string
+		returnedError  error
+		expectedToken  string
+		expectedClaims bool
+	}{
+		{
+			name: "missing token",
+		},
+		{
+			name:           "valid token",
+			authorization:  "Bearer _token_",
+			expectedToken:  "_token_",
+			expectedClaims: true,
+		},
+		{
+			name:          "invalid token",
+			authorization: "Bearer _token_",
+			returnedError: authentication.ErrVerification,
+			expectedToken: "_token_",
+		},
+		{
+			name:          "revoked token",
+			authorization: "Bearer _token_",
+			returnedError: authentication.ErrRevoked,
+			expectedToken: "_token_",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "whocares", nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+
+			validator := validatorMock{}
+			validator.On("ValidateToken", "_token_").Return(jwt.CClaims{Roles: []string{"admin"}}, tc.returnedError)
+
+			var (
+				token  string
+				called bool
+				ok     bool
+			)
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				_, ok = ClaimsFromContext(r.Context())
+				token, _ = tokenFromContext(r.Context())
+			}
+
+			// When
+			OptionalAuthenticate(&validator)(next)(w, r)
+
+			// Then
+			require.True(t, called)
+			require.Equal(t, tc.expectedClaims, ok)
+			require.Equal(t, tc.expectedToken, token)
+		})
+	}
+}
+
+func TestOptionalAuthenticate_Error(t *testing.T) {
+	// Given
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "whocares", nil)
+	r.Header.Set("Authorization", "Bearer _token_")
+
+	validator := validatorMock{}
+	validator.On("ValidateToken", "_token_").Return(jwt.CClaims{}, errors.New("error"))
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("next must not be called")
+	}
+
+	// When
+	OptionalAuthenticate(&validator)(next)(w, r)
+
+	// Then
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestRequireScope(t *testing.T) {
+	tt := []struct {
+		name   string
+		claims jwt.CClaims
+	}{
+		{
+			name:   "client scope",
+			claims: jwt.CClaims{Scope: "courses:read courses:write"},
+		},
+		{
+			name:   "user permission",
+			claims: jwt.CClaims{Permissions: []string{"courses:read"}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "whocares", nil)
+			r = withAuthentication(r, "_token_", tc.claims)
+
+			var called bool
+			next := func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}
+
+			// When
+			RequireScope("courses:read")(next)(w, r)
+
+			// Then
+			require.True(t, called)
+		})
+	}
+}
+
+func TestRequireScope_Errors(t *testing.T) {
+	tt := []struct {
+		name              string
+		request           func() *http.Request
+		expectedStatus    int
+		expectedChallenge string
+	}{
+		{
+			name: "not authenticated",
+			request: func() *http.Request {
+				r, _ := http.NewRequest("GET", "whocares", nil)
+				r.Header.Set("Authorization", "Bearer _token_")
+				return r
+			},
+			expectedStatus:    http.StatusUnauthorized,
+			expectedChallenge: "Bearer",
+		},
+		{
+			name: "insufficient scope",
+			request: func() *http.Request {
+				r, _ := http.NewRequest("GET", "whocares", nil)
+				return withAuthentication(r, "_token_", jwt.CClaims{Scope: "courses:write"})
+			},
+			expectedStatus:    http.StatusForbidden,
+			expectedChallenge: `Bearer error="insufficient_scope", scope="courses:read"`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			w := httptest.NewRecorder()
+			r := tc.request()
+
+			next := func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("next must not be called")
+			}
+
+			// When
+			RequireScope("courses:read")(next)(w, r)
+
+			// Then
+			require.Equal(t, tc.expectedStatus, w.Code)
+			require.Equal(t, tc.expectedChallenge, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Redirects decides where users may be sent back to after leaving this
+// service, preventing open redirects to origins that are not allowed.
+type Redirects struct {
+	defaultURL     string
+	allowedOrigins map[string]bool
+}
+
+func NewRedirects(defaultURL string, allowedOrigins []string) *Redirects {
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return &Redirects{
+		defaultURL:     defaultURL,
+		allowedOrigins: origins,
+	}
+}
+
+func (r *Redirects) Default() string {
+	return r.defaultURL
+}
+
+// IsAllowed reports whether target is a path on this service or an absolute
+// URL on an allowed origin.
+func (r *Redirects) IsAllowed(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(target, "//") && !strings.Contains(target, "\\")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	return r.allowedOrigins[u.Scheme+"://"+u.Host]
+}
+
+// Resolve returns target when it is allowed, or the default URL otherwise.
+func (r *Redirects) Resolve(target string) string {
+	if target == "" || !r.IsAllowed(target) {
+		return r.defaultURL
+	}
+
+	return target
+}
+
+// Absolute works like Resolve but turns paths on this service into absolute
+// URLs, as required by providers when redirecting back after logout.
+func (r *Redirects) Absolute(target string) string {
+	resolved := r.Resolve(target)
+
+	base, err := url.Parse(r.defaultURL)
+	if err != nil {
+		return resolved
+	}
+
+	u, err := base.Parse(resolved)
+	if err != nil {
+		return r.defaultURL
+	}
+
+	return u.String()
+}
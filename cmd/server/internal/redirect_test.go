@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirects_IsAllowed(t *testing.T) {
+	tt := []struct {
+		name    string
+		target  string
+		allowed bool
+	}{
+		{
+			name:    "allowed origin",
+			target:  "https://app.example.com/courses?id=1",
+			allowed: true,
+		},
+		{
+			name:    "allowed origin with trailing slash in config",
+			target:  "https://admin.example.com/",
+			allowed: true,
+		},
+		{
+			name:    "local path",
+			target:  "/me",
+			allowed: true,
+		},
+		{
+			name:    "unknown origin",
+			target:  "https://evil.example.com/courses",
+			allowed: false,
+		},
+		{
+			name:    "different scheme",
+			target:  "http://app.example.com/courses",
+			allowed: false,
+		},
+		{
+			name:    "different port",
+			target:  "https://app.example.com:8443/courses",
+			allowed: false,
+		},
+		{
+			name:    "protocol relative url",
+			target:  "//evil.example.com",
+			allowed: false,
+		},
+		{
+			name:    "backslash path",
+			target:  "/\\evil.example.com",
+			allowed: false,
+		},
+		{
+			name:    "javascript url",
+			target:  "javascript:alert(1)",
+			allowed: false,
+		},
+		{
+			name:    "relative path",
+			target:  "courses",
+			allowed: false,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			r := NewRedirects("https://app.example.com/home", []string{"https://app.example.com", "https://admin.example.com/"})
+
+			// When
+			allowed := r.IsAllowed(tc.target)
+
+			// Then
+			require.Equal(t, tc.allowed, allowed)
+		})
+	}
+}
+
+func TestRedirects_Resolve(t *testing.T) {
+	// Given
+	r := NewRedirects("https://app.example.com/home", []string{"https://app.example.com"})
+
+	// When
+	empty := r.Resolve("")
+	notAllowed := r.Resolve("https://evil.example.com")
+	allowed := r.Resolve("https://app.example.com/courses")
+
+	// Then
+	require.Equal(t, "https://app.example.com/home", empty)
+	require.Equal(t, "https://app.example.com/home", notAllowed)
+	require.Equal(t, "https://app.example.com/courses", allowed)
+}
+
+func TestRedirects_Absolute(t *testing.T) {
+	// Given
+	r := NewRedirects("https://app.example.com/home", []string{"https://app.example.com"})
+
+	// When
+	empty := r.Absolute("")
+	path := r.Absolute("/bye")
+	allowed := r.Absolute("https://app.example.com/bye")
+
+	// Then
+	require.Equal(t, "https://app.example.com/home", empty)
+	require.Equal(t, "https://app.example.com/bye", path)
+	require.Equal(t, "https://app.example.com/bye", allowed)
+}
@@ -1,12 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal"
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication"
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/auth"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/client"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/email"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/hook"
 	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/jwt"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/refresh"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/revocation"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/role"
+	"github.com/mateoferrari97/AnitiMonono-AuthenticationAPI/cmd/server/internal/authentication/user"
 	"github.com/mateoferrari97/Kit/web/server"
 
 	"github.com/gorilla/sessions"
@@ -20,30 +35,99 @@ func main() {
 
 func run() error {
 	var (
-		env          = getEnv()
-		port         = getPort()
-		signingKey   = getJWTSigningKey()
-		storeKey     = getStoreKey()
-		host         = getHost(env)
-		clientID     = getClientID(env)
-		clientSecret = getClientSecret(env)
+		env         = getEnv()
+		port        = getPort()
+		storeKey    = getStoreKey()
+		host        = getHost(env)
+		authConfigs = getAuthConfigs(env, host)
 	)
 
-	authenticator, err := auth.NewAuthenticator(host, clientID, clientSecret)
+	refreshTokenTTL, err := getRefreshTokenTTL()
+	if err != nil {
+		return err
+	}
+
+	revocations, err := getRevocationList()
+	if err != nil {
+		return err
+	}
+
+	keyring, err := getKeyring(refreshTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	tokenTTL, err := getTokenTTL()
+	if err != nil {
+		return err
+	}
+
+	tokenOptions, err := getTokenOptions()
+	if err != nil {
+		return err
+	}
+
+	if err := scheduleKeyRotation(keyring); err != nil {
+		return err
+	}
+
+	authenticator, err := auth.NewAuthenticator(authConfigs...)
+	if err != nil {
+		return err
+	}
+
+	clients, err := getClientRegistry()
+	if err != nil {
+		return err
+	}
+
+	roles, err := getRoleResolver()
+	if err != nil {
+		return err
+	}
+
+	users, err := getUserRepository()
+	if err != nil {
+		return err
+	}
+
+	refreshTokens, err := getRefreshTokenStorage()
+	if err != nil {
+		return err
+	}
+
+	emails, err := getEmailPolicy()
+	if err != nil {
+		return err
+	}
+
+	hooks, err := getHooks()
 	if err != nil {
 		return err
 	}
 
 	sv := server.NewServer()
-	token := jwt.NewJWT(signingKey)
-	service_ := authentication.NewService(authenticator, token)
+	token := jwt.NewJWT(keyring, revocations, host, getTokenAudience(host), tokenTTL, tokenOptions...)
+	refresher := refresh.NewRefresher(refreshTokens, refreshTokenTTL)
+	service_ := authentication.NewService(host, authenticator, token, refresher, clients, roles, users, emails, hooks)
 	storage := sessions.NewCookieStore([]byte(storeKey))
 
-	handler := internal.NewHandler(sv, service_, storage)
-	handler.Login()
+	redirects := internal.NewRedirects(getDefaultRedirectURL(host), getAllowedRedirectOrigins(host))
+
+	handler := internal.NewHandler(sv, service_, storage, redirects)
 	handler.LoginCallback()
-	handler.Logout() // server.ValidateJWT(signingKey)
-	handler.Me()     // server.ValidateJWT(signingKey)
+	handler.Login()
+	handler.Link()
+	handler.RefreshToken()
+	handler.Logout(internal.OptionalAuthenticate(service_))
+	handler.Me(internal.Authenticate(service_))
+	handler.User(internal.Authenticate(service_), internal.RequireScope("users:read"))
+	handler.UserInfo(internal.Authenticate(service_))
+	handler.JWKS()
+	handler.AuthorizationServerMetadata()
+	handler.Token()
+	handler.Introspect()
+	handler.Revoke()
 
 	return sv.Run(port)
 }
@@ -57,13 +141,215 @@ func getEnv() string {
 	return env
 }
 
-func getJWTSigningKey() string {
+// getKeyring keeps retired keys for as long as tokens may still be verified
+// with them, including the access tokens bound to refresh tokens, which are
+// renewed until refreshTokenTTL.
+func getKeyring(refreshTokenTTL time.Duration) (*jwt.Keyring, error) {
+	signingKey, err := getJWTSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	maxTokenLifetime, err := getMaxTokenLifetime()
+	if err != nil {
+		return nil, err
+	}
+
+	if refreshTokenTTL > maxTokenLifetime {
+		maxTokenLifetime = refreshTokenTTL
+	}
+
+	var previous []jwt.Key
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		if path == "" {
+			continue
+		}
+
+		key, err := jwt.LoadPEMKey("", path)
+		if err != nil {
+			return nil, err
+		}
+
+		previous = append(previous, key)
+	}
+
+	return jwt.NewKeyring(signingKey, maxTokenLifetime, previous...), nil
+}
+
+// scheduleKeyRotation rotates the signing key to the one in
+// JWT_SIGNING_KEY_FILE whenever the file changes, checking it every
+// JWT_KEY_ROTATION_INTERVAL and on SIGHUP. Keys are never generated here, so
+// instances sharing the file sign with the same key and keep it on restart.
+func scheduleKeyRotation(keyring *jwt.Keyring) error {
+	interval := os.Getenv("JWT_KEY_ROTATION_INTERVAL")
+	if os.Getenv("JWT_SIGNING_KEY_FILE") == "" {
+		if interval != "" {
+			return errors.New("JWT_KEY_ROTATION_INTERVAL requires JWT_SIGNING_KEY_FILE")
+		}
+
+		return nil
+	}
+
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return err
+		}
+
+		go keyring.RotateEvery(context.Background(), d, getJWTSigningKey)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			key, err := getJWTSigningKey()
+			if err != nil {
+				log.Printf("could not rotate signing key: %v", err)
+				continue
+			}
+
+			keyring.Rotate(key)
+			log.Printf("signing with key %s", key.ID)
+		}
+	}()
+
+	return nil
+}
+
+func getMaxTokenLifetime() (time.Duration, error) {
+	lifetime := os.Getenv("JWT_MAX_TOKEN_LIFETIME")
+	if lifetime == "" {
+		lifetime = "24h"
+	}
+
+	return time.ParseDuration(lifetime)
+}
+
+func getJWTSigningKey() (jwt.Key, error) {
+	keyID := os.Getenv("JWT_SIGNING_KEY_ID")
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		return jwt.LoadPEMKey(keyID, path)
+	}
+
 	signingKey := os.Getenv("JWT_SIGNING_KEY")
 	if signingKey == "" {
 		signingKey = "JWT_SIGNING_KEY"
 	}
 
-	return signingKey
+	return jwt.NewHMACKey(keyID, []byte(signingKey)), nil
+}
+
+func getTokenTTL() (time.Duration, error) {
+	ttl := os.Getenv("JWT_TTL")
+	if ttl == "" {
+		ttl = "1h"
+	}
+
+	return time.ParseDuration(ttl)
+}
+
+// getTokenOptions reads the leeway tolerated when validating tokens and,
+// with JWT_STRICT_CLAIMS=true, rejects tokens carrying unknown claims.
+func getTokenOptions() ([]jwt.Option, error) {
+	leeway, err := time.ParseDuration(getEnvOrDefault("JWT_LEEWAY", "30s"))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []jwt.Option{jwt.WithLeeway(leeway)}
+	if os.Getenv("JWT_STRICT_CLAIMS") == "true" {
+		opts = append(opts, jwt.WithStrictClaims())
+	}
+
+	return opts, nil
+}
+
+func getRefreshTokenTTL() (time.Duration, error) {
+	ttl := os.Getenv("REFRESH_TOKEN_TTL")
+	if ttl == "" {
+		ttl = "720h"
+	}
+
+	return time.ParseDuration(ttl)
+}
+
+func getRevocationList() (jwt.RevocationList, error) {
+	path := os.Getenv("REVOCATION_LIST_FILE")
+	if path == "" {
+		return revocation.NewMemoryList(), nil
+	}
+
+	return revocation.NewFileList(path)
+}
+
+// getUserRepository stores users in the SQLite database at USERS_DATABASE_FILE,
+// or in memory without it.
+func getUserRepository() (authentication.UserRepository, error) {
+	path := os.Getenv("USERS_DATABASE_FILE")
+	if path == "" {
+		return user.NewMemoryRepository(), nil
+	}
+
+	return user.NewSQLiteRepository(path)
+}
+
+// getRefreshTokenStorage stores refresh tokens in the SQLite database at
+// REFRESH_TOKEN_DATABASE_FILE, or in memory without it.
+func getRefreshTokenStorage() (refresh.Storage, error) {
+	path := os.Getenv("REFRESH_TOKEN_DATABASE_FILE")
+	if path == "" {
+		return refresh.NewMemoryStorage(), nil
+	}
+
+	return refresh.NewSQLiteStorage(path)
+}
+
+// getClientRegistry loads the clients allowed to call the OAuth endpoints from
+// OAUTH_CLIENTS_FILE. Without it no client is allowed.
+func getClientRegistry() (*client.Registry, error) {
+	path := os.Getenv("OAUTH_CLIENTS_FILE")
+	if path == "" {
+		return client.NewRegistry()
+	}
+
+	return client.LoadFile(path)
+}
+
+// getRoleResolver reads roles and permissions from the ID token claims under
+// ROLE_CLAIMS_NAMESPACE and from the mapping in ROLE_MAPPING_FILE.
+func getRoleResolver() (*role.Resolver, error) {
+	namespace := os.Getenv("ROLE_CLAIMS_NAMESPACE")
+
+	path := os.Getenv("ROLE_MAPPING_FILE")
+	if path == "" {
+		return role.NewResolver(namespace, role.Mapping{}), nil
+	}
+
+	return role.LoadFile(namespace, path)
+}
+
+// getEmailPolicy reads the rules deciding which email addresses can sign in
+// from EMAIL_POLICY_FILE. Without it every address can.
+func getEmailPolicy() (*email.Policy, error) {
+	path := os.Getenv("EMAIL_POLICY_FILE")
+	if path == "" {
+		return email.NewPolicy(email.Rules{}), nil
+	}
+
+	return email.LoadFile(path)
+}
+
+// getHooks returns the built-in post-authentication hooks enabled in
+// HOOKS_FILE. Custom hooks implement hook.Hook and are appended to the chain.
+func getHooks() (hook.Chain, error) {
+	path := os.Getenv("HOOKS_FILE")
+	if path == "" {
+		return hook.Chain{}, nil
+	}
+
+	return hook.LoadFile(path)
 }
 
 func getPort() string {
@@ -84,6 +370,18 @@ func getHost(env string) string {
 	return host
 }
 
+func getDefaultRedirectURL(host string) string {
+	return getEnvOrDefault("DEFAULT_REDIRECT_URL", host+"/me")
+}
+
+func getAllowedRedirectOrigins(host string) []string {
+	return strings.Split(getEnvOrDefault("ALLOWED_REDIRECT_ORIGINS", host), ",")
+}
+
+func getTokenAudience(host string) []string {
+	return strings.Split(getEnvOrDefault("JWT_AUDIENCE", host), ",")
+}
+
 func getStoreKey() string {
 	storeKey := os.Getenv("STORE_KEY")
 	if storeKey == "" {
@@ -93,6 +391,75 @@ func getStoreKey() string {
 	return storeKey
 }
 
+// getAuthConfigs builds one config per provider in AUTH_PROVIDERS. Every
+// provider reads its settings from AUTH_<NAME>_* variables, falling back to the
+// Auth0 tenant where google and microsoft are connections. Since the
+// connection in the authorization URL can be removed by the user, only the
+// subjects of that connection are accepted unless AUTH_<NAME>_ALLOWED_SUBJECT_PREFIXES
+// says otherwise.
+func getAuthConfigs(env string, host string) []auth.Config {
+	defaultAuthParams := map[string]string{
+		"google":    "connection=google-oauth2",
+		"microsoft": "connection=windowslive",
+	}
+
+	defaultSubjectPrefixes := map[string]string{
+		"google":    "google-oauth2|",
+		"microsoft": "windowslive|",
+	}
+
+	defaultScopes := map[string]string{
+		auth.TypeOIDC:   "openid profile email",
+		auth.TypeGitHub: "read:user user:email",
+	}
+
+	var configs []auth.Config
+	for _, name := range strings.Split(getEnvOrDefault("AUTH_PROVIDERS", "google,microsoft"), ",") {
+		prefix := "AUTH_" + strings.ToUpper(name) + "_"
+		providerType := getEnvOrDefault(prefix+"TYPE", auth.TypeOIDC)
+
+		authParams := make(map[string]string)
+		values, _ := url.ParseQuery(getEnvOrDefault(prefix+"AUTH_PARAMS", defaultAuthParams[name]))
+		for key := range values {
+			authParams[key] = values.Get(key)
+		}
+
+		var subjectPrefixes []string
+		if prefixes := getEnvOrDefault(prefix+"ALLOWED_SUBJECT_PREFIXES", defaultSubjectPrefixes[name]); prefixes != "" {
+			subjectPrefixes = strings.Split(prefixes, ",")
+		}
+
+		configs = append(configs, auth.Config{
+			Name:                   name,
+			Type:                   providerType,
+			IssuerURL:              getEnvOrDefault(prefix+"ISSUER_URL", getEnvOrDefault("AUTH_ISSUER_URL", "https://food4everyone.us.auth0.com/")),
+			AuthURL:                os.Getenv(prefix + "AUTH_URL"),
+			TokenURL:               os.Getenv(prefix + "TOKEN_URL"),
+			UserInfoURL:            os.Getenv(prefix + "USERINFO_URL"),
+			ClientID:               getEnvOrDefault(prefix+"CLIENT_ID", getClientID(env)),
+			ClientSecret:           getEnvOrDefault(prefix+"CLIENT_SECRET", getClientSecret(env)),
+			BaseURL:                host,
+			RedirectPath:           getEnvOrDefault("AUTH_REDIRECT_PATH", "/login/callback"),
+			Scopes:                 strings.Fields(getEnvOrDefault(prefix+"SCOPES", defaultScopes[providerType])),
+			Audience:               getEnvOrDefault(prefix+"AUDIENCE", os.Getenv("AUTH_AUDIENCE")),
+			AuthParams:             authParams,
+			AllowedSubjectPrefixes: subjectPrefixes,
+			Auth0:                  os.Getenv(prefix+"AUTH0") == "true",
+		})
+	}
+
+	return configs
+}
+
+func getEnvOrDefault(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
 func getClientID(env string) string {
 	clientID := "qHcV8N1iSntNMbZGxG6wP38sofmEK9aB"
 	if env == "production" {
@@ -7,10 +7,13 @@ go 1.16
 
 require (
 	github.com/coreos/go-oidc/v3 v3.0.0
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/sessions v1.2.1
 	github.com/mateoferrari97/Kit v0.0.2
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/oauth2 v0.0.0-20210201163806-010130855d6c
+	gopkg.in/square/go-jose.v2 v2.5.1
 )